@@ -0,0 +1,358 @@
+package util
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/samber/lo"
+	bolt "go.etcd.io/bbolt"
+)
+
+// CheckOptions 是檢查檔案完整性的選項。
+type CheckOptions struct {
+	Interval  int           // 检查完整性的週期, 单位: day
+	SizeLimit int64         // 本次檢查的體積上限, 单位: byte (至少檢查一個檔案)
+	Workers   int           // 同時檢查的檔案數量, 0 表示自動
+	MBps      int           // 讀取速度上限, 单位: MB/s, 0 表示不限
+	LikeDays  int           // 每個 Like 使上次檢查時間提早的天數, 0 表示不按 Like 加權
+	TimeLimit time.Duration // 本次檢查的時間上限, 0 表示不限
+}
+
+// NewCheckOptions 使用專案的 CheckInterval 與 CheckSizeLimit.
+func NewCheckOptions(info ProjectInfo) CheckOptions {
+	return CheckOptions{
+		Interval:  info.CheckInterval,
+		SizeLimit: int64(info.CheckSizeLimit) * MB,
+	}
+}
+
+// workers 自動模式使用 CPU 數量，但最多 4 個，因為機械硬碟並行讀取反而更慢。
+func (opts CheckOptions) workers() int {
+	if opts.Workers > 0 {
+		return opts.Workers
+	}
+	return min(runtime.NumCPU(), 4)
+}
+
+// checkResult 是一個檔案的檢查結果。
+type checkResult struct {
+	File    *File
+	Damaged bool
+	Err     error
+}
+
+// checkFlushInterval 是檢查過程中把結果寫入 file_checked.json 的間隔。
+const checkFlushInterval = 30 * time.Second
+
+// CheckFiles 檢查專案 root 中需要檢查的檔案 (上次檢查時間超過週期),
+// 从上次检查時間最早的檔案開始檢查，檔案體積合計超過 opts.SizeLimit
+// 或檢查時間超過 opts.TimeLimit 時停止。
+// 多個 worker 同時計算 checksum, 結果統一寫入 fcMap, 並定時寫入 file_checked.json.
+// 按 Ctrl-C 會中斷檢查，已檢查的結果會保留在 fcMap 中。
+// 注意，該函數運行後, fcMap 的内容也会改变。
+func CheckFiles(root string, fcMap map[string]*FileChecked, opts CheckOptions, db *bolt.DB) (checkN int, checkedSize int64, err error) {
+	files, err := filesToCheck(fcMap, opts, db)
+	if err != nil {
+		return
+	}
+	printOldestChecked(files, fcMap)
+	if len(files) == 0 {
+		return
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if opts.TimeLimit > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.TimeLimit)
+		defer cancel()
+	}
+	run := &checkRun{
+		ctx:     ctx,
+		limiter: newRateLimiter(int64(opts.MBps) * MB),
+		start:   time.Now(),
+	}
+	for _, f := range files {
+		run.total += f.Size
+	}
+
+	jobs := make(chan *File)
+	results := make(chan checkResult)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.workers(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range jobs {
+				damaged, err := run.checkFile(root, f)
+				results <- checkResult{File: f, Damaged: damaged, Err: err}
+			}
+		}()
+	}
+	go func() {
+	loop:
+		for _, f := range files {
+			select {
+			case jobs <- f:
+			case <-ctx.Done():
+				break loop
+			}
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	fileCheckedPath := filepath.Join(root, FileCheckedPath)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	lastFlush := time.Now()
+	for {
+		select {
+		case r, ok := <-results:
+			if !ok {
+				run.printProgress(checkN, len(files))
+				fmt.Println()
+				switch ctx.Err() {
+				case context.Canceled:
+					fmt.Println("已中斷檢查")
+				case context.DeadlineExceeded:
+					fmt.Println("已達到檢查時間上限:", opts.TimeLimit)
+				}
+				return
+			}
+			if r.Err != nil {
+				// 中斷時正在檢查的檔案不算已檢查。
+				// 其他錯誤則繼續接收結果，使全部 worker 能夠結束。
+				if ctx.Err() == nil {
+					err = WrapErrors(err, r.Err)
+				}
+				continue
+			}
+			fcMap[r.File.ID].Damaged = r.Damaged
+			fcMap[r.File.ID].Suspect = false
+			if !r.Damaged {
+				// 內容已確認完好。不修改被檢查的檔案，而是更新 metadata 中的 MTime,
+				// 以免快速檢查時再次被視為可疑。
+				err = WrapErrors(err, updateMTime(root, r.File, db))
+			}
+			fcMap[r.File.ID].Checked = Now()
+			checkN += 1
+			checkedSize += r.File.Size
+		case <-ticker.C:
+			run.printProgress(checkN, len(files))
+			if checkN > 0 && time.Since(lastFlush) > checkFlushInterval {
+				if _, e := WriteJSON(fcMap, fileCheckedPath); e != nil {
+					err = WrapErrors(err, e)
+				}
+				lastFlush = time.Now()
+			}
+		}
+	}
+}
+
+// updateMTime 如果 metadata 中記錄的 MTime 與檔案實際的修改時間不一致，
+// 則更新 metadata 與數據庫 (不改變 UTime)。沒有記錄 MTime 的檔案不處理。
+func updateMTime(root string, f *File, db *bolt.DB) error {
+	if f.MTime == "" {
+		return nil
+	}
+	info, err := os.Stat(filepath.Join(root, FILES, f.Filename))
+	if err != nil {
+		return err
+	}
+	mtime := MTimeOf(info)
+	if mtime == f.MTime {
+		return nil
+	}
+	newFile := *f
+	newFile.MTime = mtime
+	return RewriteFile(root, f, &newFile, db)
+}
+
+// checkRun 是一次檢查的共享狀態，用於限速、中斷與顯示進度。
+type checkRun struct {
+	ctx     context.Context
+	limiter *rateLimiter
+	start   time.Time
+	total   int64        // 待檢查的檔案體積合計
+	read    atomic.Int64 // 已讀取的體積
+}
+
+// printProgress 列印進度、速度與預計剩餘時間 (覆蓋同一行)。
+func (run *checkRun) printProgress(checkN, fileN int) {
+	read := run.read.Load()
+	elapsed := time.Since(run.start)
+	speed := float64(read) / elapsed.Seconds()
+	eta := "-"
+	if speed > 0 {
+		eta = time.Duration(float64(run.total-read) / speed * float64(time.Second)).
+			Round(time.Second).String()
+	}
+	fmt.Printf("\r%d/%d 檔案, %s / %s, %s/s, 預計剩餘 %s    ",
+		checkN, fileN,
+		FileSizeToString(float64(read), 2),
+		FileSizeToString(float64(run.total), 2),
+		FileSizeToString(speed, 2), eta)
+}
+
+// checkCandidate 是一個需要檢查的檔案, checked 是按 Like 加權後的上次檢查時間。
+type checkCandidate struct {
+	file    *File
+	checked time.Time
+}
+
+// filesToCheck 選出需要檢查的檔案，按上次檢查時間從早到晚排序 (從未檢查的排在最前),
+// 體積合計超過 opts.SizeLimit 時停止。
+// 如果 opts.LikeDays 大於零，則每個 Like 使上次檢查時間提早 LikeDays 天，
+// 即越受重視的檔案越頻繁檢查。
+func filesToCheck(fcMap map[string]*FileChecked, opts CheckOptions, db *bolt.DB) (files []*File, err error) {
+	var candidates []checkCandidate
+	deadline := time.Now().Add(-time.Duration(opts.Interval*Day) * time.Second)
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(FilesBucket)
+		for id, fc := range fcMap {
+			// file_checked.json 與數據庫不一致時 (可執行 wuliu-checksum -renew 修正),
+			// 跳過數據庫中沒有的檔案。
+			if !KeyExistsInBucket([]byte(id), b) {
+				continue
+			}
+			f, err := GetFileInBucket(id, b)
+			if err != nil {
+				return err
+			}
+			checked, err := time.Parse(RFC3339, fc.Checked)
+			if err != nil {
+				return err
+			}
+			checked = checked.AddDate(0, 0, -f.Like*opts.LikeDays)
+			// 可疑的檔案 (見 QuickCheck) 不論上次檢查時間，都排在最前。
+			if fc.Suspect {
+				checked = time.Time{}
+			}
+			if checked.Before(deadline) {
+				candidates = append(candidates, checkCandidate{&f, checked})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+	slices.SortFunc(candidates, func(a, b checkCandidate) int {
+		if n := a.checked.Compare(b.checked); n != 0 {
+			return n
+		}
+		return cmp.Compare(a.file.ID, b.file.ID)
+	})
+
+	var size int64
+	for _, c := range candidates {
+		// len(files) > 0 是为了确保至少检查一个档案
+		if len(files) > 0 && size > opts.SizeLimit {
+			break
+		}
+		files = append(files, c.file)
+		size += c.file.Size
+	}
+	return
+}
+
+// printOldestChecked 列印待檢查檔案中最早的上次檢查時間。
+func printOldestChecked(files []*File, fcMap map[string]*FileChecked) {
+	if len(files) == 0 {
+		fmt.Println("沒有需要檢查的檔案")
+		return
+	}
+	oldest := slices.MinFunc(files, func(a, b *File) int {
+		return cmp.Compare(fcMap[a.ID].Checked, fcMap[b.ID].Checked)
+	})
+	checked := fcMap[oldest.ID].Checked
+	if checked == Epoch {
+		checked = "從未檢查"
+	}
+	fmt.Printf("最早的上次檢查時間: %s (%s)\n", checked, oldest.Filename)
+	suspects := lo.CountBy(files, func(f *File) bool { return fcMap[f.ID].Suspect })
+	if suspects > 0 {
+		fmt.Println("可疑的檔案 (優先檢查):", suspects)
+	}
+}
+
+// CheckFile 使用 metadata 中的 checksum 的算法计算档案的 checksum, 不一致即为损坏。
+func CheckFile(root string, file File) (damaged bool, err error) {
+	run := &checkRun{ctx: context.Background()}
+	return run.checkFile(root, &file)
+}
+
+func (run *checkRun) checkFile(root string, file *File) (damaged bool, err error) {
+	fPath := filepath.Join(root, FILES, file.Filename)
+	f, err := os.Open(fPath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	algo, _ := SplitChecksum(file.Checksum)
+	h, err := NewHash(algo)
+	if err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(h, checkReader{f, run}); err != nil {
+		return false, err
+	}
+	return !ChecksumEqual(HashSum(algo, h), file.Checksum), nil
+}
+
+// checkReader 在讀取時記錄進度、限速，並在中斷時停止讀取。
+type checkReader struct {
+	r   io.Reader
+	run *checkRun
+}
+
+func (cr checkReader) Read(p []byte) (int, error) {
+	if err := cr.run.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := cr.r.Read(p)
+	cr.run.read.Add(int64(n))
+	cr.run.limiter.wait(n)
+	return n, err
+}
+
+// rateLimiter 限制多個 worker 合計的讀取速度。
+type rateLimiter struct {
+	bytesPerSec int64
+	start       time.Time
+	mu          sync.Mutex
+	total       int64
+}
+
+// newRateLimiter 如果 bytesPerSec 不大於零，則返回 nil, 表示不限速。
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &rateLimiter{bytesPerSec: bytesPerSec, start: time.Now()}
+}
+
+// wait 記錄已讀取 n bytes, 如果讀取速度超過上限則暫停。
+func (l *rateLimiter) wait(n int) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.total += int64(n)
+	// 使用 float64 計算，避免 total 很大時 (約 9.2 GB 以上) 乘以 time.Second 溢出。
+	due := l.start.Add(time.Duration(float64(l.total) / float64(l.bytesPerSec) * float64(time.Second)))
+	l.mu.Unlock()
+	time.Sleep(time.Until(due))
+}
@@ -37,8 +37,9 @@ func FolderMustEmpty(folder string) {
 	}
 }
 
-func MakeFolders(verbose bool) {
+func MakeFolders(root string, verbose bool) {
 	for _, folder := range Folders {
+		folder = filepath.Join(root, folder)
 		if verbose {
 			fmt.Println("Create folder:", folder)
 		}
@@ -50,10 +51,11 @@ func ExecutableDir() string {
 	return filepath.Dir(GetExePath())
 }
 
-func InitFileChecked() {
+func InitFileChecked(root string) {
 	m := make(map[int]int)
-	fmt.Println("Create", FileCheckedPath)
-	_ = lo.Must(WriteJSON(m, FileCheckedPath))
+	fileCheckedPath := filepath.Join(root, FileCheckedPath)
+	fmt.Println("Create", fileCheckedPath)
+	_ = lo.Must(WriteJSON(m, fileCheckedPath))
 }
 
 func ReadFileChecked(root string) (fcMap map[string]*FileChecked, err error) {
@@ -67,9 +69,19 @@ func ReadFileChecked(root string) (fcMap map[string]*FileChecked, err error) {
 }
 
 func ReadProjectInfo(root string) (info ProjectInfo) {
+	return lo.Must(LoadProjectInfo(root))
+}
+
+// LoadProjectInfo 與 ReadProjectInfo 相同，但出錯時返回錯誤 (例如備份專案無法讀取)。
+func LoadProjectInfo(root string) (info ProjectInfo, err error) {
 	infoPath := filepath.Join(root, ProjectInfoPath)
-	data := lo.Must(os.ReadFile(infoPath))
-	lo.Must0(json.Unmarshal(data, &info))
+	data, err := os.ReadFile(infoPath)
+	if err != nil {
+		return
+	}
+	if err = json.Unmarshal(data, &info); err != nil {
+		err = fmt.Errorf("%s: %w", infoPath, err)
+	}
 	return
 }
 
@@ -103,8 +115,13 @@ func notAllowInBackup(isBackup bool) error {
 }
 
 func FindOrphans() (fileOrphans, metaOrphans []string, err error) {
-	files, e1 := NamesInFiles()
-	metas, e2 := namesInMetadataTrim()
+	return FindOrphansIn(".")
+}
+
+// FindOrphansIn 與 FindOrphans 相同，但可指定專案根目錄 root.
+func FindOrphansIn(root string) (fileOrphans, metaOrphans []string, err error) {
+	files, e1 := GetFilenamesBase(filepath.Join(root, FILES))
+	metas, e2 := namesInMetadataTrim(root)
 	if err = WrapErrors(e1, e2); err != nil {
 		return
 	}
@@ -113,7 +130,8 @@ func FindOrphans() (fileOrphans, metaOrphans []string, err error) {
 }
 
 // NewFilesFrom 把档案名 (names) 转换为 files, 此时假设档案在 folder 资料夹内。
-func NewFilesFrom(names []string, folder string) (files []*File, err error) {
+// algo 是计算 checksum 的算法。
+func NewFilesFrom(names []string, folder, algo string) (files []*File, err error) {
 	for _, name := range names {
 		filePath := filepath.Join(folder, name)
 		info, err := os.Lstat(filePath)
@@ -124,13 +142,14 @@ func NewFilesFrom(names []string, folder string) (files []*File, err error) {
 			fmt.Printf("%s 是資料夾, 自動忽略\n", filePath)
 			continue
 		}
-		checksum, err := FileSum512(filePath)
+		checksum, err := FileSum(filePath, algo)
 		if err != nil {
 			return nil, err
 		}
 		f := NewFile(name)
 		f.Checksum = checksum
 		f.Size = info.Size()
+		f.MTime = MTimeOf(info)
 		f.Type = TypeByFilename(name)
 		f.Keywords = []string{}
 		f.Collections = []string{}
@@ -152,12 +171,8 @@ func NamesInInput() ([]string, error) {
 	return GetFilenamesBase(INPUT)
 }
 
-func namesInMetadata() ([]string, error) {
-	return GetFilenamesBase(METADATA)
-}
-
-func namesInMetadataTrim() ([]string, error) {
-	names, err := namesInMetadata()
+func namesInMetadataTrim(root string) ([]string, error) {
+	names, err := GetFilenamesBase(filepath.Join(root, METADATA))
 	if err != nil {
 		return nil, err
 	}
@@ -169,27 +184,43 @@ func namesInMetadataTrim() ([]string, error) {
 
 // deleteFileByName 尝试删除档案，例如 name=abc.txt,
 // 则尝试删除 files/abc.txt 和 metadata/abc.txt.json。
-// 注意，这里说的删除是将档案移动到专案根目录的 recyclebin 中，
-// 如果 recyclebin 里有同名档案则直接覆盖。
-func deleteFileByName(name string) {
+// 注意，这里说的删除是将档案移动到 recyclebin 里的一个新资料夹中
+// (资料夹名称由删除时间与 ID 组成), 因此同名档案多次删除也不会互相覆盖。
+// 每次移动前都先写入 journal, 以便中断后可执行 wuliu-recover 回滚。
+func deleteFileByName(name string, j *Journal) error {
 	f := filepath.Join(FILES, name)
 	m := filepath.Join(METADATA, name+".json")
+	folder := NewRecycleFolder(NameToID(name))
+	j.Create(folder)
+	if err := j.Save(); err != nil {
+		return err
+	}
+	if err := os.Mkdir(folder, NormalDirPerm); err != nil {
+		return err
+	}
 	for _, oldpath := range []string{f, m} {
 		if PathNotExists(oldpath) {
 			fmt.Println("NotFound =>", oldpath)
 		} else {
-			newpath := filepath.Join(RECYCLEBIN, filepath.Base(oldpath))
+			newpath := filepath.Join(folder, filepath.Base(oldpath))
+			j.Move(oldpath, newpath)
+			if err := j.Save(); err != nil {
+				return err
+			}
 			fmt.Println("move =>", newpath)
 			if err := os.Rename(oldpath, newpath); err != nil {
 				fmt.Println(err)
 			}
 		}
 	}
+	return nil
 }
 
-func deleteFilesByName(names []string, db *bolt.DB) error {
+func deleteFilesByName(names []string, j *Journal, db *bolt.DB) error {
 	for _, name := range names {
-		deleteFileByName(name)
+		if err := deleteFileByName(name, j); err != nil {
+			return err
+		}
 	}
 	ids := NamesToIds(names)
 	return DeleteInDB(ids, db)
@@ -197,14 +228,17 @@ func deleteFilesByName(names []string, db *bolt.DB) error {
 
 // DeleteFilesByID 尝试删除档案，包括档案本身, metadata 以及数据库条目。
 // 注意，这里说的删除是将档案移动到专案根目录的 recyclebin 中，
-// 如果 recyclebin 里有同名档案则直接覆盖。
-func DeleteFilesByID(ids []string, db *bolt.DB) error {
+// 可使用 wuliu-restore 恢复。
+// j 由调用者负责 Begin 和 End.
+func DeleteFilesByID(ids []string, j *Journal, db *bolt.DB) error {
 	names, err := IdsToNames(ids, db)
 	if err != nil {
 		return err
 	}
 	for _, name := range names {
-		deleteFileByName(name)
+		if err := deleteFileByName(name, j); err != nil {
+			return err
+		}
 	}
 	return DeleteInDB(ids, db)
 }
@@ -292,7 +326,7 @@ func AddToFileChecked(files []*File) error {
 		return err
 	}
 	for _, f := range files {
-		fc := &FileChecked{f.ID, f.CTime, false}
+		fc := &FileChecked{ID: f.ID, Checked: f.CTime, Damaged: false}
 		fcMap[f.ID] = fc
 	}
 	_, err = WriteJSON(fcMap, FileCheckedPath)
@@ -311,6 +345,45 @@ func DeleteFromFileChecked(ids []string) error {
 	return err
 }
 
+// ReconcileFileChecked 以数据库为准修正 file_checked.json,
+// 添加缺少的档案 (上次检查时间设为 epoch), 删除多余的档案。
+func ReconcileFileChecked(db *bolt.DB) error {
+	fcMap, err := ReadFileChecked(".")
+	if err != nil {
+		return err
+	}
+	if fcMap, err = ReconcileFileCheckedMap(fcMap, db); err != nil {
+		return err
+	}
+	fmt.Println("Update =>", FileCheckedPath)
+	_, err = WriteJSON(fcMap, FileCheckedPath)
+	return err
+}
+
+// ReconcileFileCheckedMap 与 ReconcileFileChecked 相同，但只修改 fcMap, 不写入档案。
+func ReconcileFileCheckedMap(fcMap map[string]*FileChecked, db *bolt.DB) (map[string]*FileChecked, error) {
+	if fcMap == nil {
+		fcMap = make(map[string]*FileChecked)
+	}
+	files, err := GetAllFiles(db)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool)
+	for _, f := range files {
+		ids[f.ID] = true
+		if _, ok := fcMap[f.ID]; !ok {
+			fcMap[f.ID] = &FileChecked{ID: f.ID, Checked: Epoch, Damaged: false}
+		}
+	}
+	for id := range fcMap {
+		if !ids[id] {
+			delete(fcMap, id)
+		}
+	}
+	return fcMap, nil
+}
+
 func DamagedOfFileChecked(fcMap map[string]*FileChecked) (ids []string) {
 	for _, fc := range fcMap {
 		if fc.Damaged == true {
@@ -67,9 +67,9 @@ func OpenDB(root string) (*bolt.DB, error) {
 		dbPath, NormalDirPerm, &bolt.Options{Timeout: 1 * time.Second})
 }
 
-func CreateDatabase() {
-	fmt.Println("Create", DatabasePath)
-	db := lo.Must(OpenDB("."))
+func CreateDatabase(root string) {
+	fmt.Println("Create", filepath.Join(root, DatabasePath))
+	db := lo.Must(OpenDB(root))
 	defer db.Close()
 	lo.Must0(createBuckets(db))
 }
@@ -79,6 +79,7 @@ func createBuckets(db *bolt.DB) error {
 		for _, name := range Buckets {
 			lo.Must(tx.CreateBucketIfNotExists(name))
 		}
+		lo.Must(tx.CreateBucketIfNotExists(MerkleBucket))
 		return nil
 	})
 }
@@ -122,6 +123,9 @@ func AddFilesToDB(files []FileAndMeta, db *bolt.DB) error {
 			if err := PutToBucket([]byte(f.ID), f.Metadata, filesBuc); err != nil {
 				return err
 			}
+			if err := UpdateIndexes(nil, f.File, tx); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
@@ -131,9 +135,16 @@ func DeleteInDB(ids []string, db *bolt.DB) error {
 	return db.Update(func(tx *bolt.Tx) error {
 		filesBuc := tx.Bucket(FilesBucket)
 		for _, id := range ids {
+			old, err := GetFileInBucket(id, filesBuc)
+			if err != nil {
+				return err
+			}
 			if err := filesBuc.Delete([]byte(id)); err != nil {
 				return err
 			}
+			if err := UpdateIndexes(&old, nil, tx); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
@@ -204,23 +215,38 @@ func DatabaseFilesSize(db *bolt.DB) (fileN int, totalSize int64, err error) {
 
 // RebuildDatabase 删除数据库，然后重建数据库并且重新填充数据。
 func RebuildDatabase(root string) {
+	lo.Must0(RecreateDatabase(root))
+}
+
+// RecreateDatabase 與 RebuildDatabase 相同，但出錯時返回錯誤。
+func RecreateDatabase(root string) error {
 	dbPath := filepath.Join(root, DatabasePath)
 	if PathExists(dbPath) {
 		fmt.Println("Delete", dbPath)
-		lo.Must0(os.Remove(dbPath))
+		if err := os.Remove(dbPath); err != nil {
+			return err
+		}
 	}
 	fmt.Println("Rebuilding database...")
-	db := lo.Must(OpenDB(root))
+	db, err := OpenDB(root)
+	if err != nil {
+		return err
+	}
 	defer db.Close()
-	lo.Must0(createBuckets(db))
-	lo.Must0(rebuildAllBuckets(db))
+	if err := createBuckets(db); err != nil {
+		return err
+	}
+	if err := rebuildAllBuckets(root, db); err != nil {
+		return err
+	}
 	fmt.Println("OK")
+	return nil
 }
 
 // rebuildAllBuckets 重建全部数据桶，几乎等于重建整个数据库。
-func rebuildAllBuckets(db *bolt.DB) error {
+func rebuildAllBuckets(root string, db *bolt.DB) error {
 	return db.Update(func(tx *bolt.Tx) error {
-		if err := rebuildFilesBucket(tx); err != nil {
+		if err := rebuildFilesBucket(root, tx); err != nil {
 			return err
 		}
 		files, e1 := GetAllFilesTx(tx)
@@ -299,27 +325,84 @@ func RebuildCTimeBucket(db *bolt.DB) error {
 	})
 }
 
-// RenameCTime 在更改檔案名稱時順便更新 CTimeBucket.
-func RenameCTime(ctime, oldid, newid string, tx *bolt.Tx) error {
-	ctimeBuc := tx.Bucket(CTimeBucket)
-	ids, err := bucketGetStrSlice(ctime, ctimeBuc)
-	if err != nil {
+// indexKeys 返回一個檔案在各個索引 bucket 中的 key (空字符串表示不需要索引),
+// 與 rebuildSomeBuckets 的規則一致。
+func indexKeys(f *File) map[string][]string {
+	return map[string][]string{
+		string(ChecksumBucket):    f.AllChecksums(),
+		string(SizeBucket):        {intToKey(f.Size)},
+		string(TypeBucket):        {f.Type},
+		string(LikeBucket):        {intToKey(int64(f.Like))},
+		string(LabelBucket):       {f.Label},
+		string(NotesBucket):       {f.Notes},
+		string(KeywordsBucket):    f.Keywords,
+		string(CollectionsBucket): f.Collections,
+		string(AlbumsBucket):      f.Albums,
+		string(CTimeBucket):       {f.CTime},
+		string(UTimeBucket):       {f.UTime},
+		string(FilenameBucket):    {f.Filename},
+	}
+}
+
+func intToKey(i int64) string {
+	if i == 0 {
+		return ""
+	}
+	return strconv.FormatInt(i, 10)
+}
+
+// UpdateIndexes 把 oldFile 從全部索引中刪除，再把 newFile 添加到全部索引中,
+// 並更新 Merkle tree.
+// oldFile 為 nil 表示新增檔案, newFile 為 nil 表示刪除檔案。
+// 注意，該函數不處理 FilesBucket.
+func UpdateIndexes(oldFile, newFile *File, tx *bolt.Tx) error {
+	if err := UpdateMerkle(oldFile, newFile, tx); err != nil {
 		return err
 	}
-	if ids == nil {
-		return fmt.Errorf("RenameCTime: get '%s' is nil", ctime)
+	if oldFile != nil {
+		for name, keys := range indexKeys(oldFile) {
+			b := tx.Bucket([]byte(name))
+			for _, key := range keys {
+				if err := deleteIdFromKey(key, oldFile.ID, b); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if newFile != nil {
+		for name, keys := range indexKeys(newFile) {
+			b := tx.Bucket([]byte(name))
+			for _, key := range keys {
+				if err := putStrAndIDs(key, newFile.ID, b); err != nil {
+					return err
+				}
+			}
+		}
 	}
-	delete(ids, oldid)
-	ids[newid] = true
-	return bucketPutMapAsSlice(ctime, ids, ctimeBuc)
+	return nil
 }
 
-func rebuildFilesBucket(tx *bolt.Tx) error {
+func deleteIdFromKey(key, id string, b *bolt.Bucket) error {
+	if key == "" {
+		return nil
+	}
+	ids, err := bucketGetStrSlice(key, b)
+	if err != nil || ids == nil {
+		return err
+	}
+	delete(ids, id)
+	if len(ids) == 0 {
+		return b.Delete([]byte(key))
+	}
+	return bucketPutMapAsSlice(key, ids, b)
+}
+
+func rebuildFilesBucket(root string, tx *bolt.Tx) error {
 	filesBuc, err := reCreateBucket(FilesBucket, tx)
 	if err != nil {
 		return err
 	}
-	files, err := GetAllFilesTxMetadata()
+	files, err := GetAllFilesTxMetadata(root)
 	if err != nil {
 		return err
 	}
@@ -331,8 +414,9 @@ func rebuildFilesBucket(tx *bolt.Tx) error {
 	return nil
 }
 
-func GetAllFilesTxMetadata() ([]*File, error) {
-	metaPaths, err := getAllMetadataPaths()
+// GetAllFilesTxMetadata 讀取專案 root 中的全部 metadata.
+func GetAllFilesTxMetadata(root string) ([]*File, error) {
+	metaPaths, err := getAllMetadataPaths(root)
 	if err != nil {
 		return nil, err
 	}
@@ -354,15 +438,15 @@ func metaPathsToFiles(paths []string) (files []*File, err error) {
 	return files, nil
 }
 
-func getAllMetadataPaths() ([]string, error) {
-	a, b, err := FindOrphans()
+func getAllMetadataPaths(root string) ([]string, error) {
+	a, b, err := FindOrphansIn(root)
 	if err != nil {
 		return nil, err
 	}
 	if len(a)+len(b) > 0 {
 		return nil, fmt.Errorf("發現孤立檔案，請執行 wuliu-orphan")
 	}
-	return filepath.Glob(filepath.Join(METADATA, "/*"))
+	return filepath.Glob(filepath.Join(root, METADATA, "/*"))
 }
 
 func rebuildSomeBuckets(files []*File, tx *bolt.Tx) error {
@@ -383,7 +467,7 @@ func rebuildSomeBuckets(files []*File, tx *bolt.Tx) error {
 	}
 
 	for _, f := range files {
-		e1 := putStrAndIDs(f.Checksum, f.ID, csumBuc)
+		e1 := putSliceAndIDs(f.AllChecksums(), f.ID, csumBuc)
 		e2 := putIntAndIDs(f.Size, f.ID, sizeBuc)
 		e3 := putStrAndIDs(f.Type, f.ID, typeBuc)
 		e4 = putIntAndIDs(int64(f.Like), f.ID, likeBuc)
@@ -401,7 +485,7 @@ func rebuildSomeBuckets(files []*File, tx *bolt.Tx) error {
 			return err
 		}
 	}
-	return nil
+	return rebuildMerkle(files, tx)
 }
 
 func GetAllFiles(db *bolt.DB) (files []*File, err error) {
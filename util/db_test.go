@@ -0,0 +1,93 @@
+package util
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestDeleteInDBUpdatesIndexes(t *testing.T) {
+	root := t.TempDir()
+	CreateDatabase(root)
+	db, err := OpenDB(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	sum := strings.Repeat("ab", 64)
+	a, b := NewFile("a.txt"), NewFile("b.txt")
+	a.Checksum, b.Checksum = sum, sum
+	a.Size, b.Size = 10, 10
+	var files []FileAndMeta
+	for _, f := range []*File{a, b} {
+		data, err := json.Marshal(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, FileAndMeta{File: f, Metadata: data})
+	}
+	if err := AddFilesToDB(files, db); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := DeleteInDB([]string{b.ID}, db); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := GetIDsByChecksum(sum, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != a.ID {
+		t.Fatalf("ChecksumBucket: got %v, want [%s]", ids, a.ID)
+	}
+	groups, err := FindDuplicates(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 0 {
+		t.Fatalf("FindDuplicates after delete: got %d groups, want 0", len(groups))
+	}
+}
+
+func TestRebuildSomeBucketsNormalizesChecksums(t *testing.T) {
+	root := t.TempDir()
+	CreateDatabase(root)
+	db, err := OpenDB(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// 模擬舊版本的數據庫: checksum 與索引的 key 都沒有算法前綴。
+	sum := strings.Repeat("cd", 64)
+	f := NewFile("a.txt")
+	f.Checksum = sum
+	data, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(FilesBucket).Put([]byte(f.ID), data); err != nil {
+			return err
+		}
+		return bucketPutJson(sum, []string{f.ID}, tx.Bucket(ChecksumBucket))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RebuildSomeBuckets(db); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := GetIDsByChecksum(sum, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(ids, []string{f.ID}) {
+		t.Fatalf("got %v, want [%s] under the prefixed key", ids, f.ID)
+	}
+}
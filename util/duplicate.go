@@ -0,0 +1,152 @@
+package util
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+
+	"github.com/samber/lo"
+	bolt "go.etcd.io/bbolt"
+)
+
+// FindDuplicates 通過 ChecksumBucket 找出內容相同的檔案。
+// 一個檔案可能有多個算法的 checksum (例如遷移算法中斷後), 因此 ID 有重疊的組
+// 會合併為一組 (union-find), 每個檔案只會出現在一組中。
+// 每組檔案中排在第一位的是建議保留的檔案 (見 SortBySurvivor),
+// 各組之間按檔案體積從大到小排序。
+func FindDuplicates(db *bolt.DB) (groups [][]*File, err error) {
+	parent := make(map[string]string)
+	var find func(id string) string
+	find = func(id string) string {
+		if parent[id] != id {
+			parent[id] = find(parent[id])
+		}
+		return parent[id]
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		err := tx.Bucket(ChecksumBucket).ForEach(func(_, v []byte) error {
+			var ids []string
+			if err := json.Unmarshal(v, &ids); err != nil {
+				return err
+			}
+			if len(ids) < 2 {
+				return nil
+			}
+			for _, id := range ids {
+				if _, ok := parent[id]; !ok {
+					parent[id] = id
+				}
+			}
+			for _, id := range ids[1:] {
+				parent[find(id)] = find(ids[0])
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		members := make(map[string][]string)
+		for id := range parent {
+			root := find(id)
+			members[root] = append(members[root], id)
+		}
+		for _, ids := range members {
+			slices.Sort(ids)
+			files, err := GetFilesByIDs(ids, tx)
+			if err != nil {
+				return err
+			}
+			SortBySurvivor(files)
+			groups = append(groups, files)
+		}
+		return nil
+	})
+	slices.SortStableFunc(groups, func(a, b []*File) int {
+		if n := cmp.Compare(b[0].Size, a[0].Size); n != 0 {
+			return n
+		}
+		return cmp.Compare(a[0].ID, b[0].ID)
+	})
+	return
+}
+
+// SortBySurvivor 把內容相同的檔案排序，建議保留的檔案排在第一位:
+// Like 最多的優先，其次是入庫時間 (CTime) 最早的。
+func SortBySurvivor(files []*File) {
+	slices.SortFunc(files, func(a, b *File) int {
+		if n := cmp.Compare(b.Like, a.Like); n != 0 {
+			return n
+		}
+		if n := cmp.Compare(a.CTime, b.CTime); n != 0 {
+			return n
+		}
+		return cmp.Compare(a.ID, b.ID)
+	})
+}
+
+// GetIDsByChecksum 返回 checksum 相同的檔案的 ID, 找不到時返回 nil.
+func GetIDsByChecksum(sum string, db *bolt.DB) (ids []string, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(ChecksumBucket).Get([]byte(NormalizeChecksum(sum)))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &ids)
+	})
+	return
+}
+
+// MergeFileAttrs 把 others 的屬性合併到 survivor 中，返回合併後的檔案:
+// Keywords, Collections, Albums 取並集, Like 取最大值,
+// Label 與 Notes 只在 survivor 中為空時纔採用 others 中第一個不為空的值。
+// 檔案名稱、checksum 等其他屬性保持不變。
+func MergeFileAttrs(survivor File, others ...*File) File {
+	merged := survivor
+	for _, f := range others {
+		merged.Like = max(merged.Like, f.Like)
+		if merged.Label == "" {
+			merged.Label = f.Label
+		}
+		if merged.Notes == "" {
+			merged.Notes = f.Notes
+		}
+		merged.Keywords = lo.Union(merged.Keywords, f.Keywords)
+		merged.Collections = lo.Union(merged.Collections, f.Collections)
+		merged.Albums = lo.Union(merged.Albums, f.Albums)
+	}
+	return merged
+}
+
+// FileAttrsEqual 判斷兩個檔案的可編輯屬性是否相同。
+func FileAttrsEqual(a, b File) bool {
+	return a.Like == b.Like && a.Label == b.Label && a.Notes == b.Notes &&
+		slices.Equal(a.Keywords, b.Keywords) &&
+		slices.Equal(a.Collections, b.Collections) &&
+		slices.Equal(a.Albums, b.Albums)
+}
+
+// UpdateFileAttrs 把 newFile 寫入當前專案的 metadata 與數據庫 (包括全部索引),
+// 並更新 UTime. oldFile 是數據庫中原來的檔案。
+func UpdateFileAttrs(oldFile, newFile *File, db *bolt.DB) error {
+	newFile.UTime = Now()
+	fmt.Println("Update =>", filepath.Join(METADATA, newFile.Filename+".json"))
+	return RewriteFile(".", oldFile, newFile, db)
+}
+
+// RewriteFile 把 newFile 寫入專案 root 的 metadata 與數據庫 (包括全部索引),
+// 不改變 UTime. oldFile 是數據庫中原來的檔案。
+func RewriteFile(root string, oldFile, newFile *File, db *bolt.DB) error {
+	metaPath := filepath.Join(root, METADATA, newFile.Filename+".json")
+	data, err := WriteJSON(newFile, metaPath)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(FilesBucket).Put([]byte(newFile.ID), data); err != nil {
+			return err
+		}
+		return UpdateIndexes(oldFile, newFile, tx)
+	})
+}
@@ -0,0 +1,44 @@
+package util
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFindDuplicatesMergesOverlappingGroups(t *testing.T) {
+	root := t.TempDir()
+	CreateDatabase(root)
+	db, err := OpenDB(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// 遷移算法中斷後: A, B 有兩個算法的 checksum, C 只有一個。
+	b2 := "blake2b-512:" + strings.Repeat("ab", 64)
+	sha := "sha256:" + strings.Repeat("cd", 32)
+	a, b, c := NewFile("a.txt"), NewFile("b.txt"), NewFile("c.txt")
+	a.Checksum, a.Checksums = sha, []string{b2}
+	b.Checksum, b.Checksums = sha, []string{b2}
+	c.Checksum = b2
+	var files []FileAndMeta
+	for _, f := range []*File{a, b, c} {
+		data, err := json.Marshal(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, FileAndMeta{File: f, Metadata: data})
+	}
+	if err := AddFilesToDB(files, db); err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := FindDuplicates(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || len(groups[0]) != 3 {
+		t.Fatalf("got %d groups, want 1 group of 3 files", len(groups))
+	}
+}
@@ -2,7 +2,10 @@ module github.com/ahui2016/wuliu/util
 
 go 1.21.0
 
+require github.com/klauspost/reedsolomon v1.10.0
+
 require (
+	github.com/klauspost/cpuid/v2 v2.1.0 // indirect
 	github.com/samber/lo v1.39.0 // indirect
 	github.com/vmihailenco/msgpack/v5 v5.4.1 // indirect
 	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
@@ -0,0 +1,160 @@
+package util
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/samber/lo"
+	"golang.org/x/crypto/blake2b"
+)
+
+// checksum 的格式是 "算法:十六進制數值", 例如 "sha256:9f86d0...".
+// 沒有算法前綴的舊 checksum 一律視為 BLAKE2b-512.
+// 對比 checksum 時必須先規範化 (見 NormalizeChecksum), 或使用 ChecksumEqual.
+
+// DefaultHashAlgorithm 是新專案默認使用的算法，也是沒有前綴的 checksum 的算法。
+const DefaultHashAlgorithm = "blake2b-512"
+
+// HashAlgorithms 是支持的算法。
+var HashAlgorithms = map[string]func() hash.Hash{
+	"blake2b-512": func() hash.Hash { return lo.Must(blake2b.New512(nil)) },
+	"blake2b-256": func() hash.Hash { return lo.Must(blake2b.New256(nil)) },
+	"sha256":      sha256.New,
+	"sha512":      sha512.New,
+}
+
+// CheckHashAlgorithm 檢查是否支持該算法。
+func CheckHashAlgorithm(algo string) error {
+	if _, ok := HashAlgorithms[algo]; !ok {
+		names := lo.Keys(HashAlgorithms)
+		slices.Sort(names)
+		return fmt.Errorf("不支持的算法: %s (可用: %s)", algo, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// NewHash 返回一個計算 algo 的 hash.Hash.
+func NewHash(algo string) (hash.Hash, error) {
+	if err := CheckHashAlgorithm(algo); err != nil {
+		return nil, err
+	}
+	return HashAlgorithms[algo](), nil
+}
+
+// SplitChecksum 把 checksum 分為算法與十六進制數值，沒有前綴時算法是 BLAKE2b-512.
+func SplitChecksum(checksum string) (algo, hexSum string) {
+	algo, hexSum, ok := strings.Cut(checksum, ":")
+	if !ok {
+		return DefaultHashAlgorithm, strings.ToLower(checksum)
+	}
+	return algo, strings.ToLower(hexSum)
+}
+
+// NormalizeChecksum 返回帶有算法前綴的 checksum.
+func NormalizeChecksum(checksum string) string {
+	if checksum == "" {
+		return ""
+	}
+	algo, hexSum := SplitChecksum(checksum)
+	return algo + ":" + hexSum
+}
+
+// ChecksumEqual 判斷兩個 checksum 是否相同 (算法與數值都相同)。
+func ChecksumEqual(a, b string) bool {
+	return NormalizeChecksum(a) == NormalizeChecksum(b)
+}
+
+// NewHashFor 返回一個使用 checksum 自帶算法的 hash.Hash.
+func NewHashFor(checksum string) (hash.Hash, error) {
+	algo, _ := SplitChecksum(checksum)
+	return NewHash(algo)
+}
+
+// HashMatches 判斷 h 的計算結果是否與 checksum 相同, h 必須由 NewHashFor(checksum) 創建。
+func HashMatches(h hash.Hash, checksum string) bool {
+	algo, _ := SplitChecksum(checksum)
+	return ChecksumEqual(HashSum(algo, h), checksum)
+}
+
+// HashSum 返回帶有算法前綴的 checksum.
+func HashSum(algo string, h hash.Hash) string {
+	return algo + ":" + hex.EncodeToString(h.Sum(nil))
+}
+
+// FileSum 使用算法 algo 計算檔案的 checksum (帶有算法前綴)。
+func FileSum(name, algo string) (string, error) {
+	h, err := NewHash(algo)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return HashSum(algo, h), nil
+}
+
+// VerifyChecksum 使用 checksum 自帶的算法計算檔案，判斷是否與 checksum 一致。
+func VerifyChecksum(name, checksum string) (ok bool, err error) {
+	algo, _ := SplitChecksum(checksum)
+	sum, err := FileSum(name, algo)
+	if err != nil {
+		return false, err
+	}
+	return ChecksumEqual(sum, checksum), nil
+}
+
+// HashAlgo 返回專案使用的算法, project.json 中沒有設定時使用 DefaultHashAlgorithm.
+func (info ProjectInfo) HashAlgo() string {
+	if info.HashAlgorithm == "" {
+		return DefaultHashAlgorithm
+	}
+	return info.HashAlgorithm
+}
+
+// AllChecksums 返回檔案的全部 checksum (規範化後), 第一個是主 checksum.
+func (f *File) AllChecksums() (sums []string) {
+	for _, sum := range append([]string{f.Checksum}, f.Checksums...) {
+		if sum = NormalizeChecksum(sum); sum != "" && !slices.Contains(sums, sum) {
+			sums = append(sums, sum)
+		}
+	}
+	return
+}
+
+// ChecksumOf 返回檔案使用算法 algo 的 checksum (規範化後), 沒有則返回空字符串。
+func (f *File) ChecksumOf(algo string) string {
+	sum, _ := lo.Find(f.AllChecksums(), func(sum string) bool {
+		a, _ := SplitChecksum(sum)
+		return a == algo
+	})
+	return sum
+}
+
+// HasChecksum 判斷 checksum 是否與檔案的任何一個 checksum 相同。
+func (f *File) HasChecksum(checksum string) bool {
+	return slices.Contains(f.AllChecksums(), NormalizeChecksum(checksum))
+}
+
+// SameContent 通過 checksum 判斷兩個檔案的內容是否相同。
+// 只對比兩者都有的算法，沒有共同算法時視為不同。
+func SameContent(a, b *File) bool {
+	for _, sum := range a.AllChecksums() {
+		algo, _ := SplitChecksum(sum)
+		if other := b.ChecksumOf(algo); other != "" {
+			return sum == other
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// FileMove 表示一次 os.Rename, 從 Src 移動到 Dst.
+type FileMove struct {
+	Src string
+	Dst string
+}
+
+// Journal 記錄一個命令計劃執行的步驟 (預寫日誌)。
+// 在執行每一步之前先把計劃寫入 journal.json, 全部完成後再刪除 journal.json,
+// 因此如果 journal.json 存在，就說明上次操作中途中斷了，需要執行 wuliu-recover.
+type Journal struct {
+	Op       string     // add/delete/rename/overwrite
+	Time     string     // RFC3339 開始時間
+	Moves    []FileMove // 按順序執行的檔案移動
+	Created  []string   // 新建的檔案 (例如 metadata 裏的 json)
+	Rewrites []*File    // 需要寫入 metadata 的檔案屬性 (只用於 overwrite)
+}
+
+func NewJournal(op string) *Journal {
+	j := new(Journal)
+	j.Op = op
+	j.Time = Now()
+	return j
+}
+
+// Move 記錄一次檔案移動，需要執行 Save 纔會寫入硬盤。
+func (j *Journal) Move(src, dst string) {
+	j.Moves = append(j.Moves, FileMove{src, dst})
+}
+
+// Create 記錄一個新建的檔案，需要執行 Save 纔會寫入硬盤。
+func (j *Journal) Create(name string) {
+	j.Created = append(j.Created, name)
+}
+
+// Rewrite 記錄一個需要寫入 metadata 的檔案屬性，需要執行 Save 纔會寫入硬盤。
+func (j *Journal) Rewrite(f *File) {
+	j.Rewrites = append(j.Rewrites, f)
+}
+
+// Begin 開始記錄。如果發現未完成的操作，則拒絕開始。
+func (j *Journal) Begin() error {
+	if PathExists(JournalPath) {
+		return fmt.Errorf("發現未完成的操作 (%s), 請先執行 wuliu-recover", JournalPath)
+	}
+	return j.Save()
+}
+
+func (j *Journal) Save() error {
+	_, err := WriteJSON(j, JournalPath)
+	return err
+}
+
+// End 表示全部步驟都已完成，刪除 journal.json
+func (j *Journal) End() error {
+	return os.Remove(JournalPath)
+}
+
+// ReadJournal 讀取 journal.json, 如果 journal.json 不存在則返回 nil.
+func ReadJournal() (*Journal, error) {
+	if PathNotExists(JournalPath) {
+		return nil, nil
+	}
+	data, err := os.ReadFile(JournalPath)
+	if err != nil {
+		return nil, err
+	}
+	j := new(Journal)
+	err = json.Unmarshal(data, j)
+	return j, err
+}
+
+// Recover 恢復中斷的操作。
+// overwrite 會把舊檔案覆蓋掉，無法回滾，因此重做 (replay);
+// 其他操作都只是移動檔案，因此回滾 (rollback)。
+// 注意，該函數只處理 files/metadata/recyclebin 裏的檔案，之後還需要重建數據庫。
+func (j *Journal) Recover() error {
+	if j.Op == "overwrite" {
+		return j.replay()
+	}
+	return j.rollback()
+}
+
+func (j *Journal) rollback() error {
+	for i := len(j.Moves) - 1; i >= 0; i-- {
+		m := j.Moves[i]
+		if PathNotExists(m.Dst) {
+			continue // 未執行
+		}
+		if PathExists(m.Src) {
+			fmt.Println("Warning! 兩邊都有檔案，請手動處理:", m.Src, m.Dst)
+			continue
+		}
+		fmt.Printf("Rollback: %s => %s\n", m.Dst, m.Src)
+		if err := os.Rename(m.Dst, m.Src); err != nil {
+			return err
+		}
+	}
+	for _, name := range j.Created {
+		if PathExists(name) {
+			fmt.Println("Delete =>", name)
+			if err := os.Remove(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (j *Journal) replay() error {
+	for _, m := range j.Moves {
+		if PathNotExists(m.Src) {
+			continue // 已執行
+		}
+		fmt.Printf("Replay: %s => %s\n", m.Src, m.Dst)
+		if err := os.Rename(m.Src, m.Dst); err != nil {
+			return err
+		}
+	}
+	for _, f := range j.Rewrites {
+		metaPath := filepath.Join(METADATA, f.Filename+".json")
+		fmt.Println("Replay =>", metaPath)
+		if _, err := WriteJSON(f, metaPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
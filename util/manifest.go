@@ -0,0 +1,52 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// manifest 的格式與 b2sum 命令相同 (BLAKE2b-512), 每行是 "CHECKSUM  NAME",
+// 可使用 `b2sum -c MANIFEST` 檢查。
+// 如果 NAME 含有換行符或反斜杠，則與 b2sum 一樣在行首加上反斜杠，並轉義 NAME.
+
+var (
+	manifestEscaper   = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`)
+	manifestUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\r`, "\r")
+)
+
+// WriteManifestLine 寫入一行 manifest.
+func WriteManifestLine(w io.Writer, sum, name string) error {
+	prefix := ""
+	if escaped := manifestEscaper.Replace(name); escaped != name {
+		prefix, name = `\`, escaped
+	}
+	_, err := fmt.Fprintf(w, "%s%s  %s\n", prefix, sum, name)
+	return err
+}
+
+// ReadManifest 讀取 manifest, 返回 name => checksum.
+// 也支持 b2sum 的二進制模式 ("CHECKSUM *NAME").
+func ReadManifest(r io.Reader) (map[string]string, error) {
+	manifest := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		escaped := strings.HasPrefix(line, `\`)
+		line = strings.TrimPrefix(line, `\`)
+		sum, name, ok := strings.Cut(line, " ")
+		if !ok || len(name) < 2 || (name[0] != ' ' && name[0] != '*') {
+			return nil, fmt.Errorf("manifest 第 %d 行格式錯誤", n)
+		}
+		name = name[1:]
+		if escaped {
+			name = manifestUnescaper.Replace(name)
+		}
+		manifest[name] = strings.ToLower(sum)
+	}
+	return manifest, scanner.Err()
+}
@@ -0,0 +1,213 @@
+package util
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Merkle tree 用於快速對比兩個專案的數據庫是否相同 (例如主專案與備份專案)。
+//
+// 每個檔案是一個葉子，葉子的 hash 由 (ID, checksum, UTime) 計算。
+// 全部葉子按 ID 的 hash 分為 256 組，每組的 hash 由組內全部葉子 (按 ID 排序) 計算,
+// 根 hash 由 256 個組的 hash 計算。
+// 兩個專案的根 hash 相同，則全部檔案的 ID, checksum, UTime 都相同；
+// 不同時只需對比 hash 不同的組，即可找出有變化的檔案。
+//
+// MerkleBucket 中的 key:
+//
+//	"g" + 組號 (1 byte)        => 組的 hash (空組沒有這個 key)
+//	"l" + 組號 (1 byte) + ID   => 葉子的 hash
+//
+// 每次修改 FilesBucket 都必須同時更新 MerkleBucket (見 UpdateMerkle).
+// 舊版本的數據庫沒有 MerkleBucket, 執行 `wuliu-db -update cache` 後纔會生成。
+
+var MerkleBucket = []byte("MerkleBucket")
+
+const merkleGroups = 256
+
+func merkleGroup(id string) byte {
+	sum := sha256.Sum256([]byte(id))
+	return sum[0]
+}
+
+func merkleLeafKey(id string) []byte {
+	return append([]byte{'l', merkleGroup(id)}, id...)
+}
+
+func merkleGroupKey(g byte) []byte {
+	return []byte{'g', g}
+}
+
+func merkleLeaf(f *File) []byte {
+	h := sha256.New()
+	h.Write([]byte(f.ID + "\x00" + NormalizeChecksum(f.Checksum) + "\x00" + f.UTime))
+	return h.Sum(nil)
+}
+
+// UpdateMerkle 把 oldFile 從 Merkle tree 中刪除，再把 newFile 添加到 Merkle tree 中。
+// oldFile 為 nil 表示新增檔案, newFile 為 nil 表示刪除檔案。
+// 如果 ID 不變，可以只提供 newFile. 數據庫中沒有 MerkleBucket 時不做任何事。
+func UpdateMerkle(oldFile, newFile *File, tx *bolt.Tx) error {
+	b := tx.Bucket(MerkleBucket)
+	if b == nil {
+		return nil
+	}
+	groups := make(map[byte]bool)
+	if oldFile != nil {
+		if err := b.Delete(merkleLeafKey(oldFile.ID)); err != nil {
+			return err
+		}
+		groups[merkleGroup(oldFile.ID)] = true
+	}
+	if newFile != nil {
+		if err := b.Put(merkleLeafKey(newFile.ID), merkleLeaf(newFile)); err != nil {
+			return err
+		}
+		groups[merkleGroup(newFile.ID)] = true
+	}
+	for g := range groups {
+		if err := updateMerkleGroup(g, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateMerkleGroup 根據組內的全部葉子重新計算組的 hash.
+func updateMerkleGroup(g byte, b *bolt.Bucket) error {
+	h := sha256.New()
+	n := 0
+	prefix := []byte{'l', g}
+	c := b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		h.Write(v)
+		n++
+	}
+	if n == 0 {
+		return b.Delete(merkleGroupKey(g))
+	}
+	return b.Put(merkleGroupKey(g), h.Sum(nil))
+}
+
+// rebuildMerkle 根據全部檔案重建 MerkleBucket.
+func rebuildMerkle(files []*File, tx *bolt.Tx) error {
+	if tx.Bucket(MerkleBucket) != nil {
+		if err := tx.DeleteBucket(MerkleBucket); err != nil {
+			return err
+		}
+	}
+	b, err := tx.CreateBucket(MerkleBucket)
+	if err != nil {
+		return err
+	}
+	groups := make(map[byte]bool)
+	for _, f := range files {
+		if err := b.Put(merkleLeafKey(f.ID), merkleLeaf(f)); err != nil {
+			return err
+		}
+		groups[merkleGroup(f.ID)] = true
+	}
+	for g := range groups {
+		if err := updateMerkleGroup(g, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MerkleTree 是 Merkle tree 的根與全部組的 hash.
+type MerkleTree struct {
+	Root   []byte
+	Groups [merkleGroups][]byte // 空組是 nil
+}
+
+func (t *MerkleTree) RootHex() string {
+	return hex.EncodeToString(t.Root)
+}
+
+// ReadMerkleTree 讀取數據庫的 Merkle tree, 不需要遍歷檔案。
+// 數據庫中沒有 MerkleBucket 時返回 nil.
+func ReadMerkleTree(db *bolt.DB) (tree *MerkleTree, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(MerkleBucket)
+		if b == nil {
+			return nil
+		}
+		tree = new(MerkleTree)
+		h := sha256.New()
+		for g := 0; g < merkleGroups; g++ {
+			groupHash := b.Get(merkleGroupKey(byte(g)))
+			if groupHash != nil {
+				tree.Groups[g] = bytes.Clone(groupHash)
+			}
+			h.Write(tree.Groups[g])
+			h.Write([]byte{0})
+		}
+		tree.Root = h.Sum(nil)
+		return nil
+	})
+	return
+}
+
+// MerkleDiff 對比兩個數據庫的 Merkle tree, 返回有變化的檔案的 ID
+// (只在其中一個數據庫中存在的檔案，或 checksum, UTime 不同的檔案)。
+// 只需讀取 hash 不同的組，因此速度與變化的數量有關，與檔案總數幾乎無關。
+// 任何一個數據庫沒有 MerkleBucket 時, ok 為 false.
+func MerkleDiff(db1, db2 *bolt.DB) (ids []string, ok bool, err error) {
+	t1, err := ReadMerkleTree(db1)
+	if err != nil || t1 == nil {
+		return nil, false, err
+	}
+	t2, err := ReadMerkleTree(db2)
+	if err != nil || t2 == nil {
+		return nil, false, err
+	}
+	if bytes.Equal(t1.Root, t2.Root) {
+		return nil, true, nil
+	}
+	changed := make(map[string]bool)
+	for g := 0; g < merkleGroups; g++ {
+		if bytes.Equal(t1.Groups[g], t2.Groups[g]) {
+			continue
+		}
+		leaves1, err := readMerkleLeaves(byte(g), db1)
+		if err != nil {
+			return nil, false, err
+		}
+		leaves2, err := readMerkleLeaves(byte(g), db2)
+		if err != nil {
+			return nil, false, err
+		}
+		for id, leaf := range leaves1 {
+			if !bytes.Equal(leaf, leaves2[id]) {
+				changed[id] = true
+			}
+		}
+		for id := range leaves2 {
+			if _, ok := leaves1[id]; !ok {
+				changed[id] = true
+			}
+		}
+	}
+	ids = StringSetToSlice(changed)
+	slices.Sort(ids)
+	return ids, true, nil
+}
+
+// readMerkleLeaves 返回一個組內的全部葉子: ID => hash.
+func readMerkleLeaves(g byte, db *bolt.DB) (leaves map[string][]byte, err error) {
+	leaves = make(map[string][]byte)
+	err = db.View(func(tx *bolt.Tx) error {
+		prefix := []byte{'l', g}
+		c := tx.Bucket(MerkleBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			leaves[string(k[len(prefix):])] = bytes.Clone(v)
+		}
+		return nil
+	})
+	return
+}
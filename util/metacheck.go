@@ -0,0 +1,160 @@
+package util
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// MetaProblem 是一個 metadata 檔案 (metadata 資料夾中的 json 檔案) 的問題。
+type MetaProblem struct {
+	ID       string   // 由檔案名稱計算的 ID
+	Filename string   // 檔案名稱 (不含 ".json")
+	Problems []string // 問題描述
+}
+
+// ParseMetadata 嚴格地解析 metadata, 不允許未知的欄位 (通常說明 json 檔案已受損)。
+func ParseMetadata(data []byte) (f File, err error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&f)
+	return
+}
+
+// CheckMetaFile 檢查專案 root 中檔案 filename 的 metadata:
+// 能否解析, ID 與 Filename 是否一致, checksum 格式是否正確,
+// Size 與實際檔案體積是否一致, Type 與檔案名稱是否一致。
+// 沒有問題時 problems 為 nil. 無法解析時 file 為 nil.
+func CheckMetaFile(root, filename string) (file *File, problems []string) {
+	metaPath := filepath.Join(root, METADATA, filename+".json")
+	data, err := os.ReadFile(metaPath)
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+	f, err := ParseMetadata(data)
+	if err != nil {
+		return nil, []string{"無法解析: " + err.Error()}
+	}
+	return &f, CheckMetaAttrs(root, filename, &f)
+}
+
+// CheckMetaAttrs 檢查 metadata 的內容 (不包括能否解析) 與專案 root 中的檔案 filename 是否一致。
+func CheckMetaAttrs(root, filename string, f *File) (problems []string) {
+	if f.Filename != filename {
+		problems = append(problems, fmt.Sprintf("Filename 與 json 檔案名稱不一致: %s", f.Filename))
+	}
+	if f.ID != NameToID(filename) {
+		problems = append(problems, fmt.Sprintf("ID 與 Filename 不一致: %s", f.ID))
+	}
+	for _, sum := range f.AllChecksums() {
+		if err := checkChecksumFormat(sum); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if f.Type != TypeByFilename(filename) {
+		problems = append(problems, fmt.Sprintf("Type 與檔案名稱不一致: %s", f.Type))
+	}
+	info, err := os.Stat(filepath.Join(root, FILES, filename))
+	switch {
+	case err != nil:
+		problems = append(problems, "找不到檔案: "+err.Error())
+	case info.Size() != f.Size:
+		problems = append(problems, fmt.Sprintf("Size 與實際檔案體積不一致: %d ≠ %d", f.Size, info.Size()))
+	}
+	return
+}
+
+func checkChecksumFormat(checksum string) error {
+	algo, hexSum := SplitChecksum(checksum)
+	h, err := NewHash(algo)
+	if err != nil {
+		return fmt.Errorf("checksum 格式錯誤: %w", err)
+	}
+	if sum, err := hex.DecodeString(hexSum); err != nil || len(sum) != h.Size() {
+		return fmt.Errorf("checksum 格式錯誤: %s", checksum)
+	}
+	return nil
+}
+
+// CheckMetadata 檢查專案 root 中的全部 metadata (見 CheckMetaFile),
+// 並與數據庫中的記錄對比 (記錄不一致，或只在其中一方存在)。
+// 返回有問題的 metadata (按檔案名稱排序) 以及已檢查的 metadata 數量。
+func CheckMetadata(root string, db *bolt.DB) (problems []MetaProblem, checkN int, err error) {
+	// 不使用 getAllMetadataPaths, 因為缺少 metadata 的檔案也要列出。
+	metaNames, err := GetFilenamesBase(filepath.Join(root, METADATA))
+	if err != nil {
+		return nil, 0, err
+	}
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(FilesBucket)
+		found := make(map[string]bool)
+		for _, metaName := range metaNames {
+			filename, ok := strings.CutSuffix(metaName, ".json")
+			if !ok {
+				continue
+			}
+			id := NameToID(filename)
+			found[id] = true
+			checkN++
+			file, msgs := CheckMetaFile(root, filename)
+			if file != nil {
+				if msg := compareWithDB(file, id, b); msg != "" {
+					msgs = append(msgs, msg)
+				}
+			}
+			if len(msgs) > 0 {
+				problems = append(problems, MetaProblem{ID: id, Filename: filename, Problems: msgs})
+			}
+		}
+		return b.ForEach(func(k, v []byte) error {
+			if found[string(k)] {
+				return nil
+			}
+			var f File
+			if err := json.Unmarshal(v, &f); err != nil {
+				return err
+			}
+			problems = append(problems, MetaProblem{
+				ID: f.ID, Filename: f.Filename, Problems: []string{"找不到 metadata"},
+			})
+			return nil
+		})
+	})
+	slices.SortFunc(problems, func(a, b MetaProblem) int {
+		return strings.Compare(a.Filename, b.Filename)
+	})
+	return
+}
+
+func compareWithDB(file *File, id string, b *bolt.Bucket) string {
+	data := b.Get([]byte(id))
+	if data == nil {
+		return "數據庫中沒有這個檔案"
+	}
+	var dbFile File
+	if err := json.Unmarshal(data, &dbFile); err != nil {
+		return "數據庫中的記錄無法解析: " + err.Error()
+	}
+	if !reflect.DeepEqual(*file, dbFile) {
+		return "與數據庫中的記錄不一致"
+	}
+	return ""
+}
+
+// MetaDamagedOfFileChecked 返回 metadata 已受損的檔案的 ID.
+func MetaDamagedOfFileChecked(fcMap map[string]*FileChecked) (ids []string) {
+	for _, fc := range fcMap {
+		if fc.MetaDamaged {
+			ids = append(ids, fc.ID)
+		}
+	}
+	return
+}
@@ -25,6 +25,7 @@ const (
 	ProjectInfoPath = "project.json"
 	FileCheckedPath = "file_checked.json"
 	DatabasePath    = "project.db"
+	JournalPath     = "journal.json"
 )
 
 const (
@@ -35,6 +36,8 @@ const (
 	WEBPAGES   = "webpages"
 	TEMPLATES  = "webpages/templates"
 	RECYCLEBIN = "recyclebin"
+	SNAPSHOTS  = "snapshots" // 只在保留舊版本的備份專案中使用，需要時纔創建
+	PARITY     = "parity"    // 用於修復受損檔案的 parity 檔案，需要時纔創建
 )
 
 var Folders = []string{
@@ -67,6 +70,12 @@ type ProjectInfo struct {
 	CheckSizeLimit  int      // 检查完整性, 单位: MB
 	ExportSizeLimit int64    // 導出檔案體積上限，單位: MB
 	ThumbSize       [2]int   // 縮略圖尺寸
+	RecycleMaxAge   int      // recyclebin 保留期限, 單位: day (0 表示不限)
+	RecycleMaxSize  int64    // recyclebin 體積上限, 單位: MB (0 表示不限)
+
+	VersionedProjects []string // 保留舊版本 (快照) 的备份专案
+	HashAlgorithm     string   // 新檔案的 checksum 算法, 空字符串表示 blake2b-512
+	ParityPercent     int      // parity 體積佔檔案體積的百分比 (1-100), 0 表示不生成 parity
 }
 
 func NewProjectInfo(name string) (info ProjectInfo) {
@@ -78,6 +87,9 @@ func NewProjectInfo(name string) (info ProjectInfo) {
 	info.CheckSizeLimit = 1024
 	info.ExportSizeLimit = 300
 	info.ThumbSize = [2]int{150, 150}
+	info.RecycleMaxAge = 90
+	info.RecycleMaxSize = 1024
+	info.HashAlgorithm = DefaultHashAlgorithm
 	return
 }
 
@@ -87,6 +99,8 @@ type ProjectStatus struct {
 	TotalSize    int64  // 全部檔案體積合計
 	FilesCount   int    // 檔案數量合計
 	DamagedCount int    // 受損檔案數量合計
+
+	MetaDamagedCount int // metadata 受損的檔案數量合計
 }
 
 // EditFiles 用于批量修改档案属性。
@@ -122,22 +136,28 @@ type FileChecked struct {
 	ID      string // 档案名称的 CRC32
 	Checked string // RFC3339 上次校驗檔案完整性的時間
 	Damaged bool   // 上次校驗結果 (檔案是否損壞)
+
+	MetaDamaged bool `json:",omitempty"` // metadata 是否損壞 (見 wuliu-checksum -meta)
+	Suspect     bool `json:",omitempty"` // 快速檢查發現體積或修改時間不一致，等待完整檢查
 }
 
 type File struct {
-	ID          string   `json:"id"`          // 档案名称的 CRC32
-	Filename    string   `json:"filename"`    // 档案名称
-	Checksum    string   `json:"checksum"`    // BLAKE2b
-	Size        int64    `json:"size"`        // length in bytes for regular files
-	Type        string   `json:"type"`        // 檔案類型, 例: text/js, office/docx
-	Like        int      `json:"like"`        // 點贊
-	Label       string   `json:"label"`       // 标签，便於搜尋
-	Notes       string   `json:"notes"`       // 備註，便於搜尋
-	Keywords    []string `json:"keywords"`    // 關鍵詞, 便於搜尋
-	Collections []string `json:"collections"` // 集合（分组），一个档案可属于多个集合
-	Albums      []string `json:"albums"`      // 相册（专辑），主要用于图片和音乐
-	CTime       string   `json:"ctime"`       // RFC3339 檔案入庫時間
-	UTime       string   `json:"utime"`       // RFC3339 檔案更新時間
+	ID          string   `json:"id"`              // 档案名称的 CRC32
+	Filename    string   `json:"filename"`        // 档案名称
+	Checksum    string   `json:"checksum"`        // "算法:數值", 沒有前綴時是 BLAKE2b-512
+	Size        int64    `json:"size"`            // length in bytes for regular files
+	Type        string   `json:"type"`            // 檔案類型, 例: text/js, office/docx
+	Like        int      `json:"like"`            // 點贊
+	Label       string   `json:"label"`           // 标签，便於搜尋
+	Notes       string   `json:"notes"`           // 備註，便於搜尋
+	Keywords    []string `json:"keywords"`        // 關鍵詞, 便於搜尋
+	Collections []string `json:"collections"`     // 集合（分组），一个档案可属于多个集合
+	Albums      []string `json:"albums"`          // 相册（专辑），主要用于图片和音乐
+	CTime       string   `json:"ctime"`           // RFC3339 檔案入庫時間
+	UTime       string   `json:"utime"`           // RFC3339 檔案更新時間
+	MTime       string   `json:"mtime,omitempty"` // RFC3339 檔案的修改時間 (添加或覆蓋時記錄)
+
+	Checksums []string `json:"checksums,omitempty"` // 其他算法的 checksum (遷移算法時保留舊值)
 }
 
 type FileAndMeta struct {
@@ -0,0 +1,384 @@
+package util
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/reedsolomon"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// parity 檔案保存在 parity 資料夾中，檔案名稱是 "原檔案名稱.par", 用於修復輕微受損的檔案。
+//
+// 原檔案被分為若干條帶 (stripe), 每條帶有 DataShards 個數據分片 (最後一條帶不足的部分補零),
+// 每條帶生成 ParityShards 個 Reed-Solomon 校驗分片。每條帶中受損的分片不超過
+// ParityShards 個時即可修復。每個分片都記錄 CRC32, 用於判斷哪些分片受損。
+//
+// parity 檔案的格式:
+//
+//	"WULIUPAR" + 全部校驗分片 + ParityHeader (msgpack) + 8 bytes header 長度 + "WULIUPAR"
+//
+// header 放在最後，因此生成 parity 時只需讀取原檔案一次。
+
+const parityMagic = "WULIUPAR"
+
+const (
+	maxDataShards  = 128
+	minShardSize   = 64
+	maxShardSize   = 64 * 1024
+	parityTempName = ".parity.tmp"
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ParityHeader 是 parity 檔案的描述信息。
+type ParityHeader struct {
+	Checksum     string   // 生成 parity 時原檔案的 checksum
+	Size         int64    // 原檔案體積
+	ShardSize    int      // 每個分片的體積
+	DataShards   int      // 每條帶的數據分片數量
+	ParityShards int      // 每條帶的校驗分片數量
+	DataCRC      []uint32 // 全部數據分片的 CRC32
+	ParityCRC    []uint32 // 全部校驗分片的 CRC32
+}
+
+// newParityHeader 根據檔案體積與 percent (校驗分片佔數據分片的百分比) 決定分片方式。
+func newParityHeader(file *File, percent int) *ParityHeader {
+	shardSize := (file.Size/maxDataShards + minShardSize) / minShardSize * minShardSize
+	shardSize = min(max(shardSize, minShardSize), maxShardSize)
+	dataShards := min((file.Size+shardSize-1)/shardSize, maxDataShards)
+	parityShards := max((dataShards*int64(percent)+99)/100, 1)
+	return &ParityHeader{
+		Checksum:     file.Checksum,
+		Size:         file.Size,
+		ShardSize:    int(shardSize),
+		DataShards:   int(dataShards),
+		ParityShards: int(parityShards),
+	}
+}
+
+func (h *ParityHeader) stripeSize() int64 {
+	return int64(h.ShardSize * h.DataShards)
+}
+
+func (h *ParityHeader) stripes() int {
+	return int((h.Size + h.stripeSize() - 1) / h.stripeSize())
+}
+
+func (h *ParityHeader) newShards() [][]byte {
+	shards := make([][]byte, h.DataShards+h.ParityShards)
+	for i := range shards {
+		shards[i] = make([]byte, h.ShardSize)
+	}
+	return shards
+}
+
+// CheckParityPercent 檢查 project.json 中的 ParityPercent.
+func CheckParityPercent(percent int) error {
+	if percent < 1 || percent > 100 {
+		return fmt.Errorf("ParityPercent 必須是 1 至 100 (當前是 %d), 請修改 project.json", percent)
+	}
+	return nil
+}
+
+func ParityPath(root, filename string) string {
+	return filepath.Join(root, PARITY, filename+".par")
+}
+
+// ReadParityHeader 讀取 parity 檔案末尾的 header.
+func ReadParityHeader(parityPath string) (*ParityHeader, error) {
+	f, err := os.Open(parityPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readParityHeader(f)
+}
+
+func readParityHeader(f *os.File) (*ParityHeader, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	trailer := make([]byte, 8+len(parityMagic))
+	trailerAt := info.Size() - int64(len(trailer))
+	if trailerAt < int64(len(parityMagic)) {
+		return nil, fmt.Errorf("parity 檔案格式錯誤: %s", f.Name())
+	}
+	if _, err := f.ReadAt(trailer, trailerAt); err != nil {
+		return nil, err
+	}
+	if string(trailer[8:]) != parityMagic {
+		return nil, fmt.Errorf("parity 檔案格式錯誤: %s", f.Name())
+	}
+	headerLen := int64(binary.BigEndian.Uint64(trailer[:8]))
+	if headerLen > trailerAt {
+		return nil, fmt.Errorf("parity 檔案格式錯誤: %s", f.Name())
+	}
+	data := make([]byte, headerLen)
+	if _, err := f.ReadAt(data, trailerAt-headerLen); err != nil {
+		return nil, err
+	}
+	var h ParityHeader
+	if err := msgpack.Unmarshal(data, &h); err != nil {
+		return nil, err
+	}
+	paritySize := trailerAt - headerLen - int64(len(parityMagic))
+	if !h.valid(paritySize) {
+		return nil, fmt.Errorf("parity 檔案格式錯誤 (header 不一致): %s", f.Name())
+	}
+	return &h, nil
+}
+
+// valid 檢查 header 中的數值是否合理，並與校驗分片的總體積 paritySize 一致，
+// 以免受損的 parity 檔案導致修復時越界。
+func (h *ParityHeader) valid(paritySize int64) bool {
+	if h.Size < 0 || h.ShardSize < 1 || h.ShardSize > maxShardSize ||
+		h.DataShards < 1 || h.DataShards > maxDataShards ||
+		h.ParityShards < 1 || h.ParityShards > maxDataShards {
+		return false
+	}
+	stripes := h.stripes()
+	return len(h.DataCRC) == stripes*h.DataShards &&
+		len(h.ParityCRC) == stripes*h.ParityShards &&
+		paritySize == int64(stripes*h.ParityShards*h.ShardSize)
+}
+
+// ParityUpToDate 判斷檔案是否已有可用的 parity (checksum 一致，且校驗分片比例與 percent 一致)。
+func ParityUpToDate(root string, file *File, percent int) bool {
+	h, err := ReadParityHeader(ParityPath(root, file.Filename))
+	if err != nil {
+		return false
+	}
+	want := newParityHeader(file, percent)
+	return ChecksumEqual(h.Checksum, file.Checksum) && h.Size == file.Size &&
+		h.ParityShards == want.ParityShards && h.DataShards == want.DataShards
+}
+
+// CreateParity 讀取 root 中的檔案，生成 parity 檔案。
+// 同時驗證檔案的 checksum, 不一致時 (檔案已受損) 不生成 parity, 返回 false.
+// 空檔案不需要 parity, 也返回 true.
+func CreateParity(root string, file *File, percent int) (ok bool, err error) {
+	if file.Size == 0 {
+		return true, nil
+	}
+	parityDir := filepath.Join(root, PARITY)
+	if err := os.MkdirAll(parityDir, NormalDirPerm); err != nil {
+		return false, err
+	}
+	tmpPath := filepath.Join(parityDir, parityTempName)
+	ok, err = writeParity(tmpPath, filepath.Join(root, FILES, file.Filename), file, percent)
+	if err != nil || !ok {
+		return false, WrapErrors(err, os.Remove(tmpPath))
+	}
+	return true, os.Rename(tmpPath, ParityPath(root, file.Filename))
+}
+
+func writeParity(parityPath, filePath string, file *File, percent int) (ok bool, err error) {
+	h := newParityHeader(file, percent)
+	enc, err := reedsolomon.New(h.DataShards, h.ParityShards)
+	if err != nil {
+		return false, err
+	}
+	checksumHash, err := NewHashFor(file.Checksum)
+	if err != nil {
+		return false, err
+	}
+	src, err := os.Open(filePath)
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+	dst, err := os.Create(parityPath)
+	if err != nil {
+		return false, err
+	}
+	defer dst.Close()
+
+	if _, err := dst.WriteString(parityMagic); err != nil {
+		return false, err
+	}
+	shards := h.newShards()
+	stripe := make([]byte, h.stripeSize())
+	r := io.TeeReader(src, checksumHash)
+	for i := 0; i < h.stripes(); i++ {
+		n, err := io.ReadFull(r, stripe)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return false, err
+		}
+		clear(stripe[n:])
+		for j := 0; j < h.DataShards; j++ {
+			copy(shards[j], stripe[j*h.ShardSize:])
+			h.DataCRC = append(h.DataCRC, crc32.Checksum(shards[j], crcTable))
+		}
+		if err := enc.Encode(shards); err != nil {
+			return false, err
+		}
+		for _, shard := range shards[h.DataShards:] {
+			h.ParityCRC = append(h.ParityCRC, crc32.Checksum(shard, crcTable))
+			if _, err := dst.Write(shard); err != nil {
+				return false, err
+			}
+		}
+	}
+	if _, err := io.Copy(checksumHash, src); err != nil {
+		return false, err
+	}
+	if !HashMatches(checksumHash, file.Checksum) {
+		return false, nil
+	}
+
+	header, err := msgpack.Marshal(h)
+	if err != nil {
+		return false, err
+	}
+	trailer := binary.BigEndian.AppendUint64(header, uint64(len(header)))
+	trailer = append(trailer, parityMagic...)
+	if _, err := dst.Write(trailer); err != nil {
+		return false, err
+	}
+	return true, dst.Sync()
+}
+
+// RepairWithParity 使用 parity 修復 root 中的受損檔案。
+// 先在臨時檔案中修復，修復後的 checksum 與 metadata 一致纔覆蓋原檔案。
+// 沒有可用的 parity 或受損太嚴重時返回 false.
+func RepairWithParity(root string, file *File) (ok bool, err error) {
+	parityPath := ParityPath(root, file.Filename)
+	if PathNotExists(parityPath) {
+		return false, nil
+	}
+	filePath := filepath.Join(root, FILES, file.Filename)
+	tmpPath := filepath.Join(root, PARITY, parityTempName)
+	if err := CopyFile(tmpPath, filePath); err != nil {
+		return false, err
+	}
+	ok, err = repairFile(tmpPath, parityPath, file)
+	if err == nil && ok {
+		ok, err = VerifyChecksum(tmpPath, file.Checksum)
+	}
+	if err == nil && ok {
+		err = SetMTime(tmpPath, file)
+	}
+	if err != nil || !ok {
+		return false, WrapErrors(err, os.Remove(tmpPath))
+	}
+	return true, os.Rename(tmpPath, filePath)
+}
+
+func repairFile(filePath, parityPath string, file *File) (ok bool, err error) {
+	par, err := os.Open(parityPath)
+	if err != nil {
+		return false, err
+	}
+	defer par.Close()
+	h, err := readParityHeader(par)
+	if err != nil {
+		return false, err
+	}
+	if !ChecksumEqual(h.Checksum, file.Checksum) || h.Size != file.Size {
+		return false, nil // parity 已過期
+	}
+	enc, err := reedsolomon.New(h.DataShards, h.ParityShards)
+	if err != nil {
+		return false, err
+	}
+	f, err := os.OpenFile(filePath, os.O_RDWR, NormalFilePerm)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if err := f.Truncate(h.Size); err != nil {
+		return false, err
+	}
+
+	shards := h.newShards()
+	stripe := make([]byte, h.stripeSize())
+	for i := 0; i < h.stripes(); i++ {
+		offset := int64(i) * h.stripeSize()
+		n, err := f.ReadAt(stripe, offset)
+		if err != nil && err != io.EOF {
+			return false, err
+		}
+		clear(stripe[n:])
+		paritySize := int64(h.ParityShards * h.ShardSize)
+		parityAt := int64(len(parityMagic)) + int64(i)*paritySize
+		parity := make([]byte, paritySize)
+		if _, err := par.ReadAt(parity, parityAt); err != nil {
+			return false, err
+		}
+
+		var damagedData []int
+		damaged := 0
+		for j := range shards {
+			k := j - h.DataShards
+			var shard []byte
+			var crc uint32
+			if k < 0 {
+				shard = stripe[j*h.ShardSize : (j+1)*h.ShardSize]
+				crc = h.DataCRC[i*h.DataShards+j]
+			} else {
+				shard = parity[k*h.ShardSize : (k+1)*h.ShardSize]
+				crc = h.ParityCRC[i*h.ParityShards+k]
+			}
+			copy(shards[j][:h.ShardSize], shard)
+			shards[j] = shards[j][:h.ShardSize]
+			if crc32.Checksum(shard, crcTable) != crc {
+				shards[j] = shards[j][:0]
+				damaged++
+				if k < 0 {
+					damagedData = append(damagedData, j)
+				}
+			}
+		}
+		if len(damagedData) == 0 {
+			continue
+		}
+		if damaged > h.ParityShards {
+			return false, nil
+		}
+		if err := enc.ReconstructData(shards); err != nil {
+			return false, err
+		}
+		for _, j := range damagedData {
+			at := offset + int64(j*h.ShardSize)
+			n := min(int64(h.ShardSize), h.Size-at)
+			if n <= 0 {
+				continue
+			}
+			if _, err := f.WriteAt(shards[j][:n], at); err != nil {
+				return false, err
+			}
+		}
+	}
+	return true, f.Sync()
+}
+
+// RemoveParityOrphans 刪除 root 中沒有對應檔案的 parity 檔案 (例如檔案已被刪除或改名)。
+func RemoveParityOrphans(root string, filenames []string) (removed []string, err error) {
+	parityDir := filepath.Join(root, PARITY)
+	if PathNotExists(parityDir) {
+		return nil, nil
+	}
+	names, err := GetFilenamesBase(parityDir)
+	if err != nil {
+		return nil, err
+	}
+	exists := StringSliceToSet(filenames)
+	for _, name := range names {
+		filename, ok := strings.CutSuffix(name, ".par")
+		if ok && exists[filename] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(parityDir, name)); err != nil {
+			return nil, err
+		}
+		removed = append(removed, name)
+	}
+	return
+}
@@ -0,0 +1,68 @@
+package util
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestParityRejectsInconsistentHeader(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, FILES), NormalDirPerm); err != nil {
+		t.Fatal(err)
+	}
+	filePath := filepath.Join(root, FILES, "a.bin")
+	data := make([]byte, 100_000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	if err := os.WriteFile(filePath, data, NormalFilePerm); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := FileSum(filePath, DefaultHashAlgorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := &File{Filename: "a.bin", Checksum: sum, Size: int64(len(data))}
+	if ok, err := CreateParity(root, file, 10); err != nil || !ok {
+		t.Fatalf("CreateParity: %v, %v", ok, err)
+	}
+	parityPath := ParityPath(root, file.Filename)
+	h, err := ReadParityHeader(parityPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 重寫 header, 使 DataCRC 的數量與分片數量不一致。
+	h.DataCRC = h.DataCRC[:1]
+	par, err := os.ReadFile(parityPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldLen := binary.BigEndian.Uint64(par[len(par)-16 : len(par)-8])
+	par = par[:len(par)-16-int(oldLen)]
+	header, err := msgpack.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	par = append(par, header...)
+	par = binary.BigEndian.AppendUint64(par, uint64(len(header)))
+	par = append(par, parityMagic...)
+	if err := os.WriteFile(parityPath, par, NormalFilePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ReadParityHeader(parityPath); err == nil {
+		t.Fatal("want an error for an inconsistent header")
+	}
+	data[0]++
+	if err := os.WriteFile(filePath, data, NormalFilePerm); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := RepairWithParity(root, file); err == nil || ok {
+		t.Fatalf("want RepairWithParity to fail, got %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,83 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// MTimeOf 返回檔案的修改時間 (RFC3339, 精確到秒), 用於 File.MTime.
+func MTimeOf(info os.FileInfo) string {
+	return info.ModTime().Format(RFC3339)
+}
+
+// SetMTime 把檔案 name 的修改時間設為 f.MTime, 沒有記錄 MTime 時不做任何事。
+func SetMTime(name string, f *File) error {
+	if f.MTime == "" {
+		return nil
+	}
+	mtime, err := time.Parse(RFC3339, f.MTime)
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(name, mtime, mtime)
+}
+
+// SuspectFile 是快速檢查發現的可疑檔案。
+type SuspectFile struct {
+	*File
+	Problem string
+}
+
+// QuickCheck 快速檢查專案 root 中的全部檔案：只對比實際檔案的體積與修改時間
+// 是否與 metadata 中的 Size 與 MTime 一致 (沒有記錄 MTime 的檔案只對比體積),
+// 不讀取檔案內容。可疑的檔案在 fcMap 中標記為 Suspect, 下次完整檢查時優先檢查。
+// 注意，該函數運行後, fcMap 的内容也会改变。
+func QuickCheck(root string, fcMap map[string]*FileChecked, db *bolt.DB) (suspects []SuspectFile, checkN int, err error) {
+	files, err := GetAllFiles(db)
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, f := range files {
+		checkN++
+		problem, err := quickCheckFile(root, f)
+		if err != nil {
+			return nil, 0, err
+		}
+		if problem == "" {
+			continue
+		}
+		suspects = append(suspects, SuspectFile{f, problem})
+		if fc, ok := fcMap[f.ID]; ok {
+			fc.Suspect = true
+		}
+	}
+	return
+}
+
+func quickCheckFile(root string, f *File) (problem string, err error) {
+	info, err := os.Stat(filepath.Join(root, FILES, f.Filename))
+	if os.IsNotExist(err) {
+		return "找不到檔案", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if info.Size() != f.Size {
+		return fmt.Sprintf("體積不一致: %d ≠ %d", info.Size(), f.Size), nil
+	}
+	if f.MTime == "" {
+		return "", nil
+	}
+	mtime, err := time.Parse(RFC3339, f.MTime)
+	if err != nil {
+		return "", err
+	}
+	if !info.ModTime().Truncate(time.Second).Equal(mtime) {
+		return fmt.Sprintf("修改時間不一致: %s ≠ %s", MTimeOf(info), f.MTime), nil
+	}
+	return "", nil
+}
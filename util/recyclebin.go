@@ -0,0 +1,162 @@
+package util
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RecycleTimeFormat 用於 recyclebin 裏的資料夾名稱。
+const RecycleTimeFormat = "20060102-150405"
+
+// RecycleEntry 是 recyclebin 裏的一項。
+// 每次刪除一個檔案，都會在 recyclebin 裏新建一個資料夾 (名稱由刪除時間與 ID 組成),
+// 用來存放該檔案及其 metadata, 因此同名檔案多次刪除也不會互相覆蓋。
+// 舊版直接放在 recyclebin 裏的檔案，每個檔案算作一項。
+type RecycleEntry struct {
+	Name      string // recyclebin 裏的資料夾名稱 (或舊版的檔案名稱)
+	ID        string // 原檔案 ID
+	Filename  string // 原檔案名稱
+	DeletedAt string // RFC3339 刪除時間
+	Size      int64  // 佔用空間合計 (包括 metadata)
+	HasFile   bool   // 是否有檔案本身
+	HasMeta   bool   // 是否有 metadata (json)
+	IsDir     bool   // 是否新版的資料夾
+}
+
+// Path 返回該項在 recyclebin 中的路徑。
+func (e *RecycleEntry) Path() string {
+	return filepath.Join(RECYCLEBIN, e.Name)
+}
+
+// CanRestore 只有新版的資料夾，並且同時有檔案和 metadata, 纔能恢復。
+func (e *RecycleEntry) CanRestore() bool {
+	return e.IsDir && e.HasFile && e.HasMeta
+}
+
+// NewRecycleFolder 返回一個新的資料夾路徑 (不會自動創建), 用於存放被刪除的檔案。
+// 同一秒內多次刪除同一個 ID 時，在名稱末尾添加序號 (例如 "-2"), 避免資料夾已存在。
+func NewRecycleFolder(id string) string {
+	name := time.Now().Format(RecycleTimeFormat) + "-" + id
+	folder := filepath.Join(RECYCLEBIN, name)
+	for i := 2; PathExists(folder); i++ {
+		folder = filepath.Join(RECYCLEBIN, fmt.Sprintf("%s-%d", name, i))
+	}
+	return folder
+}
+
+// ReadRecyclebin 讀取 recyclebin 的全部內容，按刪除時間從新到舊排序。
+func ReadRecyclebin() (entries []*RecycleEntry, err error) {
+	items, err := os.ReadDir(RECYCLEBIN)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		var e *RecycleEntry
+		if item.IsDir() {
+			e, err = readRecycleFolder(item)
+		} else {
+			e, err = readRecycleFile(item)
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	slices.SortFunc(entries, func(a, b *RecycleEntry) int {
+		return cmp.Compare(b.DeletedAt, a.DeletedAt)
+	})
+	return
+}
+
+// 資料夾名稱例如 "20240507-153000-1ABCDEF", 如果名稱不符合該格式,
+// 則以資料夾的修改時間作為刪除時間。
+func readRecycleFolder(item os.DirEntry) (*RecycleEntry, error) {
+	e := &RecycleEntry{Name: item.Name(), IsDir: true}
+	n := len(RecycleTimeFormat)
+	deletedAt, err := time.ParseInLocation(RecycleTimeFormat, e.Name[:min(n, len(e.Name))], time.Local)
+	if err == nil && len(e.Name) > n+1 {
+		e.ID, _, _ = strings.Cut(e.Name[n+1:], "-") // 去除序號 (見 NewRecycleFolder)
+	} else {
+		info, err := item.Info()
+		if err != nil {
+			return nil, err
+		}
+		deletedAt = info.ModTime()
+	}
+	e.DeletedAt = deletedAt.Format(RFC3339)
+
+	items, err := os.ReadDir(e.Path())
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, item := range items {
+		info, err := item.Info()
+		if err != nil {
+			return nil, err
+		}
+		e.Size += info.Size()
+		names = append(names, item.Name())
+	}
+	for _, name := range names {
+		if strings.HasSuffix(name, ".json") &&
+			slices.Contains(names, strings.TrimSuffix(name, ".json")) {
+			continue
+		}
+		if slices.Contains(names, name+".json") {
+			e.Filename = name
+			e.HasFile = true
+			e.HasMeta = true
+			break
+		}
+		if strings.HasSuffix(name, ".json") {
+			e.Filename = strings.TrimSuffix(name, ".json")
+			e.HasMeta = true
+		} else {
+			e.Filename = name
+			e.HasFile = true
+		}
+	}
+	return e, nil
+}
+
+func readRecycleFile(item os.DirEntry) (*RecycleEntry, error) {
+	info, err := item.Info()
+	if err != nil {
+		return nil, err
+	}
+	e := &RecycleEntry{Name: item.Name(), Size: info.Size()}
+	e.DeletedAt = info.ModTime().Format(RFC3339)
+	if strings.HasSuffix(e.Name, ".json") {
+		e.Filename = strings.TrimSuffix(e.Name, ".json")
+		e.HasMeta = true
+	} else {
+		e.Filename = e.Name
+		e.HasFile = true
+	}
+	e.ID = NameToID(e.Filename)
+	return e, nil
+}
+
+func PrintRecycleEntries(entries []*RecycleEntry) {
+	if len(entries) == 0 {
+		fmt.Println("recyclebin 是空的。")
+		return
+	}
+	for _, e := range entries {
+		size := FileSizeToString(float64(e.Size), 0)
+		size = fmt.Sprintf("(%s)", size)
+		size = PaddingRight(size, " ", 9)
+		restorable := ""
+		if !e.CanRestore() {
+			restorable = " [不可恢復]"
+		}
+		fmt.Printf("%s\t%s\t%s %s%s\n", e.Name, e.DeletedAt, size, e.Filename, restorable)
+	}
+	fmt.Println()
+}
@@ -0,0 +1,145 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// RenameFile 更改檔案名稱。ID 與 Filename 是相關的，因此需要同時更改
+// files 裏的檔案, metadata 裏的 json, 數據庫裏的全部索引以及 file_checked.json.
+// 更改檔名不會修改 UTime, file_checked.json 裏的上次檢查時間與檢查結果也保持不變。
+func RenameFile(oldID, newname string, db *bolt.DB) error {
+	if err := CheckFilename(newname); err != nil {
+		return err
+	}
+	oldFile, err := GetFileInDB(oldID, db)
+	if err != nil {
+		return err
+	}
+	oldname := oldFile.Filename
+	oldMeta := filepath.Join(METADATA, oldname+".json")
+	newMeta := filepath.Join(METADATA, newname+".json")
+	oldPath := filepath.Join(FILES, oldname)
+	newPath := filepath.Join(FILES, newname)
+	if err := checkRenameSrcDst(oldMeta, newMeta); err != nil {
+		return err
+	}
+	if err := checkRenameSrcDst(oldPath, newPath); err != nil {
+		return err
+	}
+	if FilesExistInDB([]*File{NewFile(newname)}, db) != nil {
+		return fmt.Errorf("數據庫中已有同名檔案 (或 ID 衝突): %s", newname)
+	}
+
+	j := NewJournal("rename")
+	if err := j.Begin(); err != nil {
+		return err
+	}
+
+	fm, err := renameMeta(oldname, newname, j)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Rename: %s => %s\n", oldPath, newPath)
+	j.Move(oldPath, newPath)
+	if err := j.Save(); err != nil {
+		return err
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+
+	fmt.Println("Update database...")
+	err = db.Update(func(tx *bolt.Tx) error {
+		return renameInDB(&oldFile, fm, tx)
+	})
+	if err != nil {
+		return err
+	}
+	if err := RenameInFileChecked(oldID, fm.ID); err != nil {
+		return err
+	}
+	return j.End()
+}
+
+// renameMeta 根據舊 json 生成新 json, 然後把舊 json 移進 recyclebin.
+func renameMeta(oldname, newname string, j *Journal) (fm FileAndMeta, err error) {
+	src := filepath.Join(METADATA, oldname+".json")
+	dst := filepath.Join(METADATA, newname+".json")
+	rcFolder := NewRecycleFolder(NameToID(oldname))
+	rcBin := filepath.Join(rcFolder, oldname+".json")
+	fmt.Printf("Rename: %s => %s\n", src, dst)
+	j.Create(rcFolder)
+	j.Create(dst)
+	j.Move(src, rcBin)
+	if err = j.Save(); err != nil {
+		return
+	}
+	if err = os.Mkdir(rcFolder, NormalDirPerm); err != nil {
+		return
+	}
+	file := ReadFile(src)
+	file.Filename = newname
+	file.Type = TypeByFilename(newname)
+	file.ID = NameToID(newname)
+	meta, err := WriteJSON(file, dst)
+	if err != nil {
+		return
+	}
+	fm.File = &file
+	fm.Metadata = meta
+	err = os.Rename(src, rcBin)
+	return
+}
+
+// renameInDB 在同一個事務中更新 FilesBucket 及全部索引。
+func renameInDB(oldFile *File, newFile FileAndMeta, tx *bolt.Tx) error {
+	filesBuc := tx.Bucket(FilesBucket)
+	if err := filesBuc.Delete([]byte(oldFile.ID)); err != nil {
+		return err
+	}
+	if err := PutToBucket([]byte(newFile.ID), newFile.Metadata, filesBuc); err != nil {
+		return err
+	}
+	return UpdateIndexes(oldFile, newFile.File, tx)
+}
+
+// RenameInFileChecked 把 file_checked.json 裏的 oldID 改為 newID,
+// 保留上次檢查時間與檢查結果。
+func RenameInFileChecked(oldID, newID string) error {
+	fcMap, err := ReadFileChecked(".")
+	if err != nil {
+		return err
+	}
+	fc, ok := fcMap[oldID]
+	if !ok {
+		fc = &FileChecked{Checked: Epoch, Damaged: false}
+	}
+	delete(fcMap, oldID)
+	fc.ID = newID
+	fcMap[newID] = fc
+	_, err = WriteJSON(fcMap, FileCheckedPath)
+	return err
+}
+
+func CheckFilename(name string) (err error) {
+	if strings.ContainsAny(name, `\/:*?"<>|`) {
+		err = fmt.Errorf(`檔案名稱不允許包含這些字符 \/:*?"<>|`)
+	}
+	return
+}
+
+func checkRenameSrcDst(src, dst string) error {
+	if PathNotExists(src) {
+		return fmt.Errorf("not found: %s", src)
+	}
+	if PathExists(dst) {
+		return fmt.Errorf("file exists: %s", dst)
+	}
+	return nil
+}
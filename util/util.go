@@ -198,6 +198,57 @@ func CopyFile(dstPath, srcPath string) error {
 	return WrapErrors(err1, err2)
 }
 
+// CopyFileVerified 先把 src 复制到临时档案 tmpPath, 复制的同时计算 checksum,
+// 与 checksum 一致时纔改名为 dstPath, 因此中断后不会留下不完整的 dstPath.
+// 注意 tmpPath 与 dstPath 必须在同一个磁盘中。
+// checksum 为空字符串表示不需要验证，否则使用 checksum 自带的算法。
+// dstPath 保留 src 的修改时间 (见 File.MTime).
+func CopyFileVerified(dstPath, srcPath, tmpPath string, checksum string) error {
+	algo, _ := SplitChecksum(checksum)
+	sum, err := copyFileSum(tmpPath, srcPath, algo)
+	if err != nil {
+		return WrapErrors(err, os.Remove(tmpPath))
+	}
+	if checksum != "" && !ChecksumEqual(sum, checksum) {
+		return WrapErrors(
+			fmt.Errorf("checksum 不一致: %s", srcPath), os.Remove(tmpPath))
+	}
+	info, err := os.Stat(srcPath)
+	if err != nil {
+		return WrapErrors(err, os.Remove(tmpPath))
+	}
+	if err := os.Chtimes(tmpPath, info.ModTime(), info.ModTime()); err != nil {
+		return WrapErrors(err, os.Remove(tmpPath))
+	}
+	return os.Rename(tmpPath, dstPath)
+}
+
+// copyFileSum 复制档案，同时使用算法 algo 计算 checksum.
+func copyFileSum(dstPath, srcPath, algo string) (string, error) {
+	h, err := NewHash(algo)
+	if err != nil {
+		return "", err
+	}
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+
+	_, err1 := io.Copy(io.MultiWriter(dst, h), src)
+	err2 := dst.Sync()
+	if err := WrapErrors(err1, err2); err != nil {
+		return "", err
+	}
+	return HashSum(algo, h), nil
+}
+
 // FileSizeToString 把文件大小转换为方便人类阅读的格式。
 // fixed 指定小数点后几位, 设为负数表示不限制小数位。
 func FileSizeToString(size float64, fixed int) string {
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"slices"
+
+	"github.com/ahui2016/wuliu/util"
+	"github.com/samber/lo"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Duplicate 表示一個新檔案與已有檔案 (或同一批新檔案中的前一個檔案) 內容相同。
+type Duplicate struct {
+	New      *File
+	Existing *File
+	InBatch  bool // Existing 也是這次添加的新檔案
+}
+
+func checkDupFlag() {
+	if !slices.Contains([]string{"warn", "refuse", "merge"}, *dupFlag) {
+		log.Fatalln("參數 '-dup' 只能是 warn, refuse 或 merge")
+	}
+}
+
+// findDuplicates 通過 ChecksumBucket 找出與數據庫中已有檔案內容相同的新檔案，
+// 同時也找出同一批新檔案中內容相同的檔案。
+// 數據庫中的檔案可能使用其他算法 (見 wuliu-checksum -migrate), 因此新檔案
+// 還需要按數據庫中出現的每一種算法計算 checksum 再查找。
+func findDuplicates(files []*File, db *bolt.DB) (dups []Duplicate) {
+	algos := lo.Must(checksumAlgos(db))
+
+	// key 是 checksum, 同一批新檔案中內容相同的檔案都指向同一個 Duplicate.Existing,
+	// 使合併結果能纍積。
+	seen := make(map[string]Duplicate)
+	for _, f := range files {
+		sum := util.NormalizeChecksum(f.Checksum)
+		if dup, ok := seen[sum]; ok {
+			dup.New = f
+			dups = append(dups, dup)
+			continue
+		}
+		var ids []string
+		for _, sum := range lo.Must(checksumsOf(f, algos)) {
+			ids = append(ids, lo.Must(util.GetIDsByChecksum(sum, db))...)
+		}
+		ids = lo.Uniq(ids)
+		var sameFiles []*File
+		lo.Must0(db.View(func(tx *bolt.Tx) (err error) {
+			// 舊版本的 wuliu-delete 不會刪除 ChecksumBucket 中的 ID, 因此跳過已不存在的檔案。
+			b := tx.Bucket(util.FilesBucket)
+			ids = slices.DeleteFunc(ids, func(id string) bool {
+				return !util.KeyExistsInBucket([]byte(id), b)
+			})
+			sameFiles, err = util.GetFilesByIDs(ids, tx)
+			return
+		}))
+		if len(sameFiles) == 0 {
+			seen[sum] = Duplicate{Existing: f, InBatch: true}
+			continue
+		}
+		util.SortBySurvivor(sameFiles)
+		dup := Duplicate{New: f, Existing: sameFiles[0]}
+		seen[sum] = dup
+		dups = append(dups, dup)
+	}
+	return
+}
+
+// checksumAlgos 返回 ChecksumBucket 中出現的全部算法。
+func checksumAlgos(db *bolt.DB) (algos []string, err error) {
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(util.ChecksumBucket).ForEach(func(k, _ []byte) error {
+			algo, _ := util.SplitChecksum(string(k))
+			if !slices.Contains(algos, algo) {
+				algos = append(algos, algo)
+			}
+			return nil
+		})
+	})
+	return
+}
+
+// checksumsOf 返回 input 資料夾中的新檔案 f 使用 algos 中每一種算法的 checksum.
+func checksumsOf(f *File, algos []string) ([]string, error) {
+	sums := []string{util.NormalizeChecksum(f.Checksum)}
+	for _, algo := range algos {
+		if f.ChecksumOf(algo) != "" {
+			continue
+		}
+		sum, err := util.FileSum(filepath.Join(util.INPUT, f.Filename), algo)
+		if err != nil {
+			return nil, err
+		}
+		sums = append(sums, sum)
+	}
+	return sums, nil
+}
+
+func printDuplicates(dups []Duplicate) {
+	if len(dups) == 0 {
+		return
+	}
+	fmt.Println("【注意！】發現內容相同的檔案：")
+	for _, dup := range dups {
+		where := lo.Ternary(dup.InBatch, util.INPUT, "數據庫")
+		fmt.Printf("%s == %s (%s: %s)\n",
+			dup.New.Filename, dup.Existing.Filename, where, dup.Existing.ID)
+	}
+	switch *dupFlag {
+	case "warn":
+		fmt.Println("(-dup=warn) 仍會添加以上檔案")
+	case "refuse":
+		fmt.Println("(-dup=refuse) 不添加以上檔案，它們會保留在 input 資料夾中")
+	case "merge":
+		fmt.Println("(-dup=merge) 不添加以上檔案，而是把它們的屬性合併到內容相同的檔案中，" +
+			"它們會保留在 input 資料夾中")
+	}
+	fmt.Println()
+}
+
+// skipMark 返回預覽時新檔案 f 的標記：根據參數 '-dup' 不會添加的檔案標記為 "(不添加)".
+func skipMark(f *File, dups []Duplicate) string {
+	isDup := lo.ContainsBy(dups, func(dup Duplicate) bool { return dup.New == f })
+	if *dupFlag == "warn" || !isDup {
+		return ""
+	}
+	return " (不添加)"
+}
+
+// handleDuplicates 根據參數 '-dup' 處理內容相同的檔案，返回需要添加的檔案。
+func handleDuplicates(files []*File, dups []Duplicate, db *bolt.DB) []*File {
+	if *dupFlag == "warn" || len(dups) == 0 {
+		return files
+	}
+	dupFiles := lo.Map(dups, func(dup Duplicate, _ int) *File { return dup.New })
+	files = slices.DeleteFunc(files, func(f *File) bool {
+		return slices.Contains(dupFiles, f)
+	})
+	if *dupFlag == "refuse" {
+		return files
+	}
+
+	// merge
+	updated := make(map[string]File) // 已有檔案在數據庫中原來的樣子
+	for _, dup := range dups {
+		if !dup.InBatch {
+			if _, ok := updated[dup.Existing.ID]; !ok {
+				updated[dup.Existing.ID] = *dup.Existing
+			}
+		}
+		*dup.Existing = util.MergeFileAttrs(*dup.Existing, dup.New)
+	}
+	for _, dup := range dups {
+		oldFile, ok := updated[dup.Existing.ID]
+		if !ok {
+			continue
+		}
+		delete(updated, dup.Existing.ID)
+		if util.FileAttrsEqual(oldFile, *dup.Existing) {
+			continue
+		}
+		fmt.Println("合併屬性 =>", dup.Existing.Filename)
+		lo.Must0(util.UpdateFileAttrs(&oldFile, dup.Existing, db))
+	}
+	return files
+}
@@ -25,12 +25,14 @@ var (
 	newFlag = flag.String("newjson", "", "create a JSON file for adding files")
 	cfgPath = flag.String("json", "", "use a JSON file to add files")
 	danger  = flag.Bool("danger", false, "really do add files")
+	dupFlag = flag.String("dup", "warn", "what to do with files whose content is already in the database: warn/refuse/merge")
 )
 
 func main() {
 	flag.Parse()
 	util.MustInWuliu()
 	util.CheckNotAllowInBackup()
+	checkDupFlag()
 
 	db := lo.Must(util.OpenDB("."))
 	defer db.Close()
@@ -43,10 +45,14 @@ func main() {
 		return
 	}
 
+	dups := findDuplicates(files, db)
+	printDuplicates(dups)
+
 	if *danger {
+		files = handleDuplicates(files, dups, db)
 		addNewFiles(files, db)
 	} else {
-		printNewFiles(files, cfg)
+		printNewFiles(files, dups, cfg)
 	}
 }
 
@@ -73,7 +79,7 @@ func readConfig() (cfg EditFiles) {
 func findNewFiles() (files []*File, cfg EditFiles) {
 	inputNames := lo.Must(util.NamesInInput())
 	if *cfgPath == "" {
-		return lo.Must(util.NewFilesFrom(inputNames, util.INPUT)), cfg
+		return lo.Must(util.NewFilesFrom(inputNames, util.INPUT, hashAlgo())), cfg
 	}
 	cfg = readConfig()
 	if len(cfg.Filenames) == 0 {
@@ -87,7 +93,7 @@ func findNewFiles() (files []*File, cfg EditFiles) {
 			fmt.Println("Not Found:", name)
 		}
 	}
-	files = lo.Must(util.NewFilesFrom(filenames, util.INPUT))
+	files = lo.Must(util.NewFilesFrom(filenames, util.INPUT, hashAlgo()))
 	for i := range files {
 		files[i].Like = cfg.Like
 		files[i].Label = cfg.Label
@@ -99,7 +105,11 @@ func findNewFiles() (files []*File, cfg EditFiles) {
 	return files, cfg
 }
 
-func printNewFiles(files []*File, cfg EditFiles) {
+func hashAlgo() string {
+	return util.ReadProjectInfo(".").HashAlgo()
+}
+
+func printNewFiles(files []*File, dups []Duplicate, cfg EditFiles) {
 	if len(files) == 0 {
 		fmt.Println("在input資料夾中未發現新檔案")
 		return
@@ -108,7 +118,7 @@ func printNewFiles(files []*File, cfg EditFiles) {
 		size := util.FileSizeToString(float64(f.Size), 2)
 		size = fmt.Sprintf("(%s)", size)
 		size = util.PaddingRight(size, " ", 11)
-		fmt.Printf("%s %s\n", size, f.Filename)
+		fmt.Printf("%s %s%s\n", size, f.Filename, skipMark(f, dups))
 	}
 	if *cfgPath != "" {
 		fmt.Printf("Like: %d\n", cfg.Like)
@@ -125,6 +135,8 @@ func addNewFiles(files []*File, db *bolt.DB) {
 		fmt.Println("warning: No file to add.")
 		return
 	}
+	j := util.NewJournal("add")
+	lo.Must0(j.Begin())
 	var metadatas []FileAndMeta
 	for _, f := range files {
 		// 不知道为什么有时候这里会卡住（无法移动文件，程序停止但不崩溃）
@@ -133,10 +145,13 @@ func addNewFiles(files []*File, db *bolt.DB) {
 
 		src := filepath.Join(util.INPUT, f.Filename)
 		dst := filepath.Join(util.FILES, f.Filename)
+		metaPath := filepath.Join(util.METADATA, f.Filename+".json")
+		j.Move(src, dst)
+		j.Create(metaPath)
+		lo.Must0(j.Save())
+
 		fmt.Println("Add =>", dst)
 		lo.Must0(os.Rename(src, dst))
-
-		metaPath := filepath.Join(util.METADATA, f.Filename+".json")
 		fmt.Println("Create =>", metaPath)
 		meta := lo.Must(util.WriteJSON(f, metaPath))
 		metadatas = append(metadatas, FileAndMeta{f, meta})
@@ -145,6 +160,7 @@ func addNewFiles(files []*File, db *bolt.DB) {
 	lo.Must0(util.AddFilesToDB(metadatas, db))
 	lo.Must0(util.RebuildCTimeBucket(db))
 	lo.Must0(util.AddToFileChecked(files))
+	lo.Must0(j.End())
 	fmt.Println("OK")
 }
 
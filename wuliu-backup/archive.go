@@ -0,0 +1,533 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/ahui2016/wuliu/util"
+	"github.com/samber/lo"
+	bolt "go.etcd.io/bbolt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// 歸檔目標是一個資料夾，裏面有若干個 tar 檔案及一個目錄 archives.json.
+// 每個 tar 檔案都是自描述的，包括:
+//
+//	archive.json    本歸檔的信息 (包括自上一個歸檔以來被刪除的檔案)
+//	project.json    歸檔時的專案信息
+//	manifest.b2sum  本歸檔中全部檔案的 BLAKE2b-512 (與 b2sum 命令的格式相同)
+//	metadata/       新增或有變化的 metadata
+//	files/          新增或內容有變化的檔案
+//
+// 完整歸檔包括全部檔案，增量歸檔只包括自上一個歸檔以來的變化。
+// 從最後一個完整歸檔開始，依次解開之後的每個增量歸檔，即可重建整個專案。
+const (
+	ArchiveCatalogPath  = "archives.json" // 在歸檔資料夾中
+	ArchiveInfoName     = "archive.json"  // 在 tar 中
+	ArchiveManifestName = "manifest.b2sum"
+)
+
+// ArchiveInfo 是一個歸檔 (tar 檔案) 的信息。
+type ArchiveInfo struct {
+	Name        string   // tar 檔案名稱
+	ProjectName string   // 專案名稱
+	CreatedAt   string   // RFC3339 歸檔時間
+	Full        bool     // 是否完整歸檔
+	Previous    string   // 上一個歸檔的名稱 (完整歸檔為空字符串)
+	Deleted     []string // 自上一個歸檔以來被刪除 (或改名) 的檔案名稱
+	FilesCount  int      // 本歸檔中的檔案數量
+	MetaCount   int      // 本歸檔中的 metadata 數量
+	Size        int64    // tar 檔案體積
+}
+
+// ArchiveFileState 用於判斷自上一個歸檔以來檔案是否有變化。
+type ArchiveFileState struct {
+	Filename string
+	Checksum string
+	UTime    string
+}
+
+// ArchiveCatalog 保存在歸檔資料夾中的 archives.json.
+type ArchiveCatalog struct {
+	ProjectName string
+	Archives    []*ArchiveInfo
+	State       map[string]ArchiveFileState // 最後一個歸檔時的全部檔案: id => state
+}
+
+func readArchiveCatalog(dir string) (*ArchiveCatalog, error) {
+	catalog := &ArchiveCatalog{State: make(map[string]ArchiveFileState)}
+	catalogPath := filepath.Join(dir, ArchiveCatalogPath)
+	if util.PathNotExists(catalogPath) {
+		return catalog, nil
+	}
+	data, err := os.ReadFile(catalogPath)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, catalog)
+	return catalog, err
+}
+
+// ArchiveChanges 是需要歸檔的檔案。
+type ArchiveChanges struct {
+	Full     bool
+	Files    []*File  // 需要歸檔檔案本身及其 metadata
+	MetaOnly []*File  // 只需要歸檔 metadata
+	Deleted  []string // 被刪除的檔案名稱
+}
+
+func (c ArchiveChanges) Count() int {
+	return len(c.Files) + len(c.MetaOnly) + len(c.Deleted)
+}
+
+func (c ArchiveChanges) Size() (size int64) {
+	for _, f := range c.Files {
+		size += f.Size
+	}
+	return
+}
+
+func getArchiveChanges(catalog *ArchiveCatalog, full bool, db *bolt.DB) (c ArchiveChanges, err error) {
+	var files []*File
+	err = db.View(func(tx *bolt.Tx) error {
+		files, err = util.GetAllFilesTx(tx)
+		return err
+	})
+	if err != nil {
+		return
+	}
+	c.Full = full || len(catalog.Archives) == 0
+	if c.Full {
+		c.Files = files
+		return
+	}
+	ids := make(map[string]bool)
+	for _, f := range files {
+		ids[f.ID] = true
+		old, ok := catalog.State[f.ID]
+		switch {
+		case !ok || !f.HasChecksum(old.Checksum):
+			c.Files = append(c.Files, f)
+		case old.UTime != f.UTime || !util.ChecksumEqual(old.Checksum, f.Checksum):
+			c.MetaOnly = append(c.MetaOnly, f)
+		}
+	}
+	for id, old := range catalog.State {
+		if !ids[id] {
+			c.Deleted = append(c.Deleted, old.Filename)
+		}
+	}
+	return
+}
+
+func printArchiveChanges(c ArchiveChanges) {
+	fmt.Println(lo.Ternary(c.Full, "完整歸檔", "增量歸檔"))
+	fmt.Printf("刪除\t%d\n", len(c.Deleted))
+	fmt.Printf("屬性\t%d\n", len(c.MetaOnly))
+	fmt.Printf("檔案\t%d (%s)\n", len(c.Files), util.FileSizeToString(float64(c.Size()), 2))
+	fmt.Println()
+}
+
+// createArchive 在資料夾 dir 中新建一個歸檔，如果 dir 中沒有完整歸檔，或 full 為 true,
+// 則新建完整歸檔，否則新建增量歸檔。
+func createArchive(dir string, full, danger bool) error {
+	catalog, err := readArchiveCatalog(dir)
+	if err != nil {
+		return err
+	}
+	if catalog.ProjectName != "" && catalog.ProjectName != MainProjInfo.ProjectName {
+		return fmt.Errorf("專案名稱不一致: '%s' ≠ '%s'", MainProjInfo.ProjectName, catalog.ProjectName)
+	}
+	db, err := util.OpenDB(".")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	c, err := getArchiveChanges(catalog, full, db)
+	if err != nil {
+		return err
+	}
+	printArchiveChanges(c)
+	if !c.Full && c.Count() == 0 {
+		fmt.Println("自上一個歸檔以來沒有變化，不需要歸檔。")
+		return nil
+	}
+	if !danger {
+		fmt.Println("(尚未實際執行，使用參數 '-danger' 纔會實際執行)")
+		return nil
+	}
+
+	if err := os.MkdirAll(dir, util.NormalDirPerm); err != nil {
+		return err
+	}
+	if err := checkBackupDiskUsage(dir, c.Size()); err != nil {
+		return err
+	}
+	now := time.Now()
+	info := &ArchiveInfo{
+		Name:        fmt.Sprintf("%s-%s.tar", MainProjInfo.ProjectName, now.Format(util.RecycleTimeFormat)),
+		ProjectName: MainProjInfo.ProjectName,
+		CreatedAt:   now.Format(util.RFC3339),
+		Full:        c.Full,
+		Deleted:     c.Deleted,
+		FilesCount:  len(c.Files),
+		MetaCount:   len(c.Files) + len(c.MetaOnly),
+	}
+	if !c.Full {
+		info.Previous = catalog.Archives[len(catalog.Archives)-1].Name
+	}
+
+	archivePath := filepath.Join(dir, info.Name)
+	if util.PathExists(archivePath) {
+		return fmt.Errorf("file exists: %s", archivePath)
+	}
+	tmp := archivePath + ".tmp"
+	fmt.Println("Create =>", archivePath)
+	if err := writeArchive(tmp, info, c); err != nil {
+		return util.WrapErrors(err, removeIfExists(tmp))
+	}
+	if err := os.Rename(tmp, archivePath); err != nil {
+		return err
+	}
+	stat, err := os.Stat(archivePath)
+	if err != nil {
+		return err
+	}
+	info.Size = stat.Size()
+
+	if c.Full {
+		catalog.State = make(map[string]ArchiveFileState)
+	}
+	for _, name := range c.Deleted {
+		delete(catalog.State, util.NameToID(name))
+	}
+	for _, f := range append(c.Files, c.MetaOnly...) {
+		catalog.State[f.ID] = ArchiveFileState{f.Filename, f.Checksum, f.UTime}
+	}
+	catalog.ProjectName = MainProjInfo.ProjectName
+	catalog.Archives = append(catalog.Archives, info)
+	catalogPath := filepath.Join(dir, ArchiveCatalogPath)
+	fmt.Println("Update =>", catalogPath)
+	_, err = util.WriteJSON(catalog, catalogPath)
+	return err
+}
+
+func writeArchive(tarPath string, info *ArchiveInfo, c ArchiveChanges) error {
+	f, err := os.Create(tarPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+
+	infoJSON, err := json.MarshalIndent(info, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err := tarAddBytes(tw, ArchiveInfoName, infoJSON); err != nil {
+		return err
+	}
+	projInfo, err := os.ReadFile(util.ProjectInfoPath)
+	if err != nil {
+		return err
+	}
+	if err := tarAddBytes(tw, util.ProjectInfoPath, projInfo); err != nil {
+		return err
+	}
+	var manifest bytes.Buffer
+	for _, file := range c.Files {
+		name := path.Join(util.FILES, file.Filename)
+		if err := util.WriteManifestLine(&manifest, manifestChecksum(file), name); err != nil {
+			return err
+		}
+	}
+	if err := tarAddBytes(tw, ArchiveManifestName, manifest.Bytes()); err != nil {
+		return err
+	}
+	for _, file := range append(c.Files, c.MetaOnly...) {
+		src := filepath.Join(util.METADATA, file.Filename+".json")
+		meta, err := os.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		if err := tarAddBytes(tw, path.Join(util.METADATA, file.Filename+".json"), meta); err != nil {
+			return err
+		}
+	}
+	for i, file := range c.Files {
+		printProgress("歸檔", i+1, len(c.Files))
+		if err := tarAddFile(tw, file); err != nil {
+			return err
+		}
+	}
+	err1 := tw.Close()
+	err2 := f.Sync()
+	return util.WrapErrors(err1, err2)
+}
+
+func tarAddBytes(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    util.NormalFilePerm,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+// tarAddFile 把 files 資料夾中的檔案寫入 tar, 同時驗證 checksum.
+func tarAddFile(tw *tar.Writer, file *File) error {
+	src := filepath.Join(util.FILES, file.Filename)
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name:    path.Join(util.FILES, file.Filename),
+		Mode:    util.NormalFilePerm,
+		Size:    stat.Size(),
+		ModTime: stat.ModTime(),
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	h, err := util.NewHashFor(file.Checksum)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.MultiWriter(tw, h), f); err != nil {
+		return err
+	}
+	if !util.HashMatches(h, file.Checksum) {
+		return fmt.Errorf("checksum 不一致: %s", src)
+	}
+	return nil
+}
+
+// manifestChecksum 優先使用 BLAKE2b-512 (不加前綴，與 b2sum 兼容),
+// 沒有 BLAKE2b-512 的檔案使用帶前綴的 checksum.
+func manifestChecksum(file *File) string {
+	if sum := file.ChecksumOf(util.DefaultHashAlgorithm); sum != "" {
+		_, hexSum := util.SplitChecksum(sum)
+		return hexSum
+	}
+	return util.NormalizeChecksum(file.Checksum)
+}
+
+// archiveChain 返回從最後一個完整歸檔開始的全部歸檔。
+func archiveChain(dir string, catalog *ArchiveCatalog) ([]*ArchiveInfo, error) {
+	start := -1
+	for i := len(catalog.Archives) - 1; i >= 0; i-- {
+		if catalog.Archives[i].Full {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return nil, fmt.Errorf("找不到完整歸檔: %s", dir)
+	}
+	chain := catalog.Archives[start:]
+	for i, info := range chain {
+		if i > 0 && info.Previous != chain[i-1].Name {
+			return nil, fmt.Errorf("歸檔不連續: %s 的上一個歸檔應是 %s", info.Name, info.Previous)
+		}
+		if util.PathNotExists(filepath.Join(dir, info.Name)) {
+			return nil, fmt.Errorf("找不到歸檔: %s", filepath.Join(dir, info.Name))
+		}
+	}
+	return chain, nil
+}
+
+func printArchives(dir string) error {
+	catalog, err := readArchiveCatalog(dir)
+	if err != nil {
+		return err
+	}
+	if len(catalog.Archives) == 0 {
+		fmt.Println("無歸檔 =>", dir)
+		return nil
+	}
+	for _, info := range catalog.Archives {
+		kind := lo.Ternary(info.Full, "完整", "增量")
+		size := util.FileSizeToString(float64(info.Size), 2)
+		fmt.Printf("%s\t%s\t%s\t檔案 %d, 刪除 %d (%s)\n",
+			info.Name, info.CreatedAt, kind, info.FilesCount, len(info.Deleted), size)
+	}
+	fmt.Println()
+	return nil
+}
+
+// unarchive 從最後一個完整歸檔開始，依次解開之後的每個增量歸檔，在空資料夾 to 中重建專案。
+// 重建的專案是一個獨立的主專案 (Projects 只有 ".")。
+func unarchive(dir, to string, danger bool) error {
+	catalog, err := readArchiveCatalog(dir)
+	if err != nil {
+		return err
+	}
+	chain, err := archiveChain(dir, catalog)
+	if err != nil {
+		return err
+	}
+	fmt.Println("需要解開的歸檔:")
+	for _, info := range chain {
+		fmt.Println(filepath.Join(dir, info.Name))
+	}
+	fmt.Println()
+	if !danger {
+		fmt.Println("(尚未實際執行，使用參數 '-danger' 纔會實際執行)")
+		return nil
+	}
+
+	if err := os.MkdirAll(to, util.NormalDirPerm); err != nil {
+		return err
+	}
+	util.FolderMustEmpty(to)
+	util.MakeFolders(to, false)
+	for _, info := range chain {
+		if err := extractArchive(filepath.Join(dir, info.Name), to); err != nil {
+			return err
+		}
+	}
+	return finishUnarchive(to)
+}
+
+// extractArchive 解開一個歸檔。archive.json 是 tar 中的第一項，因此會先刪除
+// 被刪除的檔案，再寫入新的檔案。解開檔案時會與 manifest.b2sum 對比 checksum.
+func extractArchive(tarPath, to string) error {
+	fmt.Println("Extract =>", tarPath)
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var manifest map[string]string // name => checksum
+	tr := tar.NewReader(bufio.NewReader(f))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !filepath.IsLocal(hdr.Name) {
+			return fmt.Errorf("不安全的路徑: %s", hdr.Name)
+		}
+		dst := filepath.Join(to, filepath.FromSlash(hdr.Name))
+
+		switch {
+		case hdr.Name == ArchiveInfoName:
+			if err := extractArchiveInfo(tr, to); err != nil {
+				return err
+			}
+		case hdr.Name == ArchiveManifestName:
+			if manifest, err = util.ReadManifest(tr); err != nil {
+				return err
+			}
+		case strings.HasPrefix(hdr.Name, util.FILES+"/"):
+			checksum, ok := manifest[hdr.Name]
+			if !ok {
+				return fmt.Errorf("manifest 中沒有 %s", hdr.Name)
+			}
+			if err := extractFileVerified(tr, dst, checksum); err != nil {
+				return err
+			}
+			if err := os.Chtimes(dst, hdr.ModTime, hdr.ModTime); err != nil {
+				return err
+			}
+		default:
+			if err := extractFile(tr, dst); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func extractArchiveInfo(r io.Reader, to string) error {
+	var info ArchiveInfo
+	if err := json.NewDecoder(r).Decode(&info); err != nil {
+		return err
+	}
+	for _, name := range info.Deleted {
+		e1 := removeIfExists(filepath.Join(to, util.FILES, name))
+		e2 := removeIfExists(filepath.Join(to, util.METADATA, name+".json"))
+		if err := util.WrapErrors(e1, e2); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractFile(r io.Reader, dst string) error {
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err1 := io.Copy(f, r)
+	err2 := f.Close()
+	return util.WrapErrors(err1, err2)
+}
+
+func extractFileVerified(r io.Reader, dst, checksum string) error {
+	h, err := util.NewHashFor(checksum)
+	if err != nil {
+		return err
+	}
+	if err := extractFile(io.TeeReader(r, h), dst); err != nil {
+		return err
+	}
+	if !util.HashMatches(h, checksum) {
+		return fmt.Errorf("checksum 不一致: %s", dst)
+	}
+	return nil
+}
+
+// finishUnarchive 修改 project.json, 生成 file_checked.json 並重建數據庫。
+func finishUnarchive(to string) error {
+	projInfoPath := filepath.Join(to, util.ProjectInfoPath)
+	info := util.ReadProjectInfo(to)
+	info.IsBackup = false
+	info.Projects = []string{"."}
+	info.LastBackupAt = []string{util.Epoch}
+	info.VersionedProjects = nil
+	fmt.Println("Update =>", projInfoPath)
+	if _, err := util.WriteJSON(info, projInfoPath); err != nil {
+		return err
+	}
+
+	// 解開時已驗證 checksum, 因此把檢查時間設為現在。
+	files, err := util.GetAllFilesTxMetadata(to)
+	if err != nil {
+		return err
+	}
+	now := util.Now()
+	fcMap := make(map[string]*FileChecked)
+	for _, f := range files {
+		fcMap[f.ID] = &FileChecked{ID: f.ID, Checked: now, Damaged: false}
+	}
+	fileCheckedPath := filepath.Join(to, util.FileCheckedPath)
+	fmt.Println("Create", fileCheckedPath)
+	if _, err := util.WriteJSON(fcMap, fileCheckedPath); err != nil {
+		return err
+	}
+	util.RebuildDatabase(to)
+	return nil
+}
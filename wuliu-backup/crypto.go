@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/ahui2016/wuliu/util"
+	"github.com/samber/lo"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/term"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 加密檔案的格式:
+// magic (8 bytes) + 明文長度 (8 bytes) + nonce 前綴 (16 bytes) + 若干個加密分塊。
+// 每個分塊使用 XChaCha20-Poly1305 加密，nonce 是 nonce 前綴加上分塊序號，
+// 並以前面 32 bytes 作為附加數據 (AD), 因此分塊被調換、截斷或長度被修改都能被發現。
+const (
+	EncryptedHeaderPath = "wuliu_encrypted.json" // 加密目標專案的根目錄中，記錄密鑰參數
+	EncryptedIndexPath  = "index.enc"            // 加密目標專案的根目錄中，加密的索引
+	PassphraseEnv       = "WULIU_PASSPHRASE"     // 如果設置了該環境變量，則不需要輸入密碼
+
+	encMagic      = "WULIUEN1"
+	encHeaderSize = 32
+	encChunkSize  = 1 << 20 // 1MB
+)
+
+// EncryptedHeader 保存在加密目標專案的根目錄中 (不加密)。
+type EncryptedHeader struct {
+	RepoName    string
+	ProjectName string
+	Salt        string // base64, argon2id 的 salt
+	Time        uint32 // argon2id 參數
+	Memory      uint32 // argon2id 參數, 單位: KiB
+	Threads     uint8  // argon2id 參數
+	Verifier    string // base64, 用密鑰加密的 RepoName, 用於驗證密碼
+}
+
+// encryptionKeys 保存已驗證的密鑰 (bkRoot => key), 避免同一個目標專案重複輸入密碼。
+var encryptionKeys = make(map[string][]byte)
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// newEncryptedHeader 生成新的 salt 與密鑰, 返回 header 與密鑰。
+func newEncryptedHeader(projectName, passphrase string) (h EncryptedHeader, key []byte, err error) {
+	salt := make([]byte, 16)
+	if _, err = rand.Read(salt); err != nil {
+		return
+	}
+	h.RepoName = util.RepoName
+	h.ProjectName = projectName
+	h.Salt = base64.StdEncoding.EncodeToString(salt)
+	h.Time = 3
+	h.Memory = 64 * 1024
+	h.Threads = 4
+	if key, err = h.deriveKey(passphrase); err != nil {
+		return
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return
+	}
+	verifier := aead.Seal(nonce, nonce, []byte(util.RepoName), nil)
+	h.Verifier = base64.StdEncoding.EncodeToString(verifier)
+	return
+}
+
+func (h EncryptedHeader) deriveKey(passphrase string) ([]byte, error) {
+	salt, err := base64.StdEncoding.DecodeString(h.Salt)
+	if err != nil {
+		return nil, err
+	}
+	key := argon2.IDKey([]byte(passphrase), salt, h.Time, h.Memory, h.Threads, chacha20poly1305.KeySize)
+	return key, nil
+}
+
+// verify 檢查密鑰是否正確。
+func (h EncryptedHeader) verify(key []byte) error {
+	verifier, err := base64.StdEncoding.DecodeString(h.Verifier)
+	if err != nil {
+		return err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return err
+	}
+	if len(verifier) < aead.NonceSize() {
+		return fmt.Errorf("Verifier 格式錯誤")
+	}
+	nonce, ciphertext := verifier[:aead.NonceSize()], verifier[aead.NonceSize():]
+	if _, err := aead.Open(nil, nonce, ciphertext, nil); err != nil {
+		return fmt.Errorf("密碼錯誤")
+	}
+	return nil
+}
+
+func readEncryptedHeader(bkRoot string) (h EncryptedHeader, err error) {
+	data, err := os.ReadFile(filepath.Join(bkRoot, EncryptedHeaderPath))
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(data, &h)
+	return
+}
+
+// isEncrypted 判斷目標專案是否加密目標專案。
+func isEncrypted(bkRoot string) bool {
+	return util.PathExists(filepath.Join(bkRoot, EncryptedHeaderPath))
+}
+
+// getEncryptionKey 讀取密碼並生成密鑰，驗證密碼是否正確。
+func getEncryptionKey(bkRoot string) ([]byte, error) {
+	if key, ok := encryptionKeys[bkRoot]; ok {
+		return key, nil
+	}
+	h, err := readEncryptedHeader(bkRoot)
+	if err != nil {
+		return nil, err
+	}
+	passphrase, err := readPassphrase("請輸入密碼 (" + bkRoot + "): ")
+	if err != nil {
+		return nil, err
+	}
+	key, err := h.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.verify(key); err != nil {
+		return nil, err
+	}
+	encryptionKeys[bkRoot] = key
+	return key, nil
+}
+
+// readPassphrase 優先使用環境變量 WULIU_PASSPHRASE, 否則從標準輸入讀取一行。
+// 標準輸入是終端時，輸入的密碼不會顯示。
+func readPassphrase(prompt string) (string, error) {
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	fmt.Print(prompt)
+	var line string
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		data, err := term.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			return "", err
+		}
+		line = string(data)
+	} else {
+		var err error
+		line, err = stdin.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+	}
+	passphrase := strings.TrimRight(line, "\r\n")
+	if passphrase == "" {
+		return "", fmt.Errorf("密碼不可為空")
+	}
+	return passphrase, nil
+}
+
+// readNewPassphrase 讀取新密碼，如果不是從環境變量讀取，則需要輸入兩次。
+func readNewPassphrase() (string, error) {
+	passphrase, err := readPassphrase("請設定密碼: ")
+	if err != nil || os.Getenv(PassphraseEnv) != "" {
+		return passphrase, err
+	}
+	again, err := readPassphrase("請再次輸入密碼: ")
+	if err != nil {
+		return "", err
+	}
+	if again != passphrase {
+		return "", fmt.Errorf("兩次輸入的密碼不一致")
+	}
+	return passphrase, nil
+}
+
+func chunkNonce(prefix []byte, i uint64) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint64(nonce[len(prefix):], i)
+	return nonce
+}
+
+// encryptStream 從 src 讀取 size bytes, 加密後寫入 dst.
+func encryptStream(dst io.Writer, src io.Reader, size int64, key []byte) error {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, encHeaderSize)
+	copy(header, encMagic)
+	binary.BigEndian.PutUint64(header[8:16], uint64(size))
+	if _, err := rand.Read(header[16:]); err != nil {
+		return err
+	}
+	if _, err := dst.Write(header); err != nil {
+		return err
+	}
+	buf := make([]byte, encChunkSize)
+	remaining := size
+	for i := uint64(0); i == 0 || remaining > 0; i++ {
+		n := min(remaining, encChunkSize)
+		if _, err := io.ReadFull(src, buf[:n]); err != nil {
+			return err
+		}
+		sealed := aead.Seal(nil, chunkNonce(header[16:], i), buf[:n], header)
+		if _, err := dst.Write(sealed); err != nil {
+			return err
+		}
+		remaining -= n
+	}
+	return nil
+}
+
+// decryptStream 從 src 讀取加密數據，解密後寫入 dst.
+func decryptStream(dst io.Writer, src io.Reader, key []byte) error {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, encHeaderSize)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return err
+	}
+	if string(header[:8]) != encMagic {
+		return fmt.Errorf("不是 wuliu 加密檔案")
+	}
+	remaining := int64(binary.BigEndian.Uint64(header[8:16]))
+	buf := make([]byte, encChunkSize+aead.Overhead())
+	for i := uint64(0); i == 0 || remaining > 0; i++ {
+		n := min(remaining, encChunkSize)
+		sealed := buf[:n+int64(aead.Overhead())]
+		if _, err := io.ReadFull(src, sealed); err != nil {
+			return err
+		}
+		plain, err := aead.Open(nil, chunkNonce(header[16:], i), sealed, header)
+		if err != nil {
+			return fmt.Errorf("解密失敗 (密碼錯誤或檔案受損)")
+		}
+		if _, err := dst.Write(plain); err != nil {
+			return err
+		}
+		remaining -= n
+	}
+	if n, _ := src.Read(buf[:1]); n > 0 {
+		return fmt.Errorf("解密失敗 (檔案末尾有多餘數據)")
+	}
+	return nil
+}
+
+// encryptFile 先把 srcPath 加密到臨時檔案 tmpPath, 加密的同時計算明文的 checksum,
+// 與 checksum 一致時纔改名為 dstPath. checksum 為空字符串表示不需要驗證。
+func encryptFile(dstPath, srcPath, tmpPath string, checksum util.HexString, key []byte) error {
+	err := encryptToTemp(tmpPath, srcPath, checksum, key)
+	if err != nil {
+		return util.WrapErrors(err, removeIfExists(tmpPath))
+	}
+	return os.Rename(tmpPath, dstPath)
+}
+
+func encryptToTemp(tmpPath, srcPath string, checksum util.HexString, key []byte) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	h, err := util.NewHashFor(checksum)
+	if err != nil {
+		return err
+	}
+	err1 := encryptStream(dst, io.TeeReader(src, h), info.Size(), key)
+	err2 := dst.Sync()
+	if err := util.WrapErrors(err1, err2); err != nil {
+		return err
+	}
+	if checksum != "" && !util.HashMatches(h, checksum) {
+		return fmt.Errorf("checksum 不一致: %s", srcPath)
+	}
+	return nil
+}
+
+// decryptFile 先把 srcPath 解密到臨時檔案 tmpPath, 解密的同時計算 checksum,
+// 與 checksum 一致時纔改名為 dstPath.
+func decryptFile(dstPath, srcPath, tmpPath string, checksum util.HexString, key []byte) error {
+	err := decryptToTemp(tmpPath, srcPath, checksum, key)
+	if err != nil {
+		return util.WrapErrors(err, removeIfExists(tmpPath))
+	}
+	return os.Rename(tmpPath, dstPath)
+}
+
+func decryptToTemp(tmpPath, srcPath string, checksum util.HexString, key []byte) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	h, err := util.NewHashFor(checksum)
+	if err != nil {
+		return err
+	}
+	err1 := decryptStream(io.MultiWriter(dst, h), bufio.NewReader(src), key)
+	err2 := dst.Sync()
+	if err := util.WrapErrors(err1, err2); err != nil {
+		return err
+	}
+	if checksum != "" && !util.HashMatches(h, checksum) {
+		return fmt.Errorf("checksum 不一致: %s", srcPath)
+	}
+	return nil
+}
+
+// writeEncryptedJSON 把 data 轉換為 JSON 並加密，先寫入臨時檔案再改名。
+func writeEncryptedJSON(data any, filename string, key []byte) error {
+	plain, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := encryptStream(&buf, bytes.NewReader(plain), int64(len(plain)), key); err != nil {
+		return err
+	}
+	tmp := filename + ".tmp"
+	if err := util.WriteFile(tmp, buf.Bytes()); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+func readEncryptedJSON(data any, filename string, key []byte) error {
+	sealed, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := decryptStream(&buf, bytes.NewReader(sealed), key); err != nil {
+		return err
+	}
+	return json.Unmarshal(buf.Bytes(), data)
+}
+
+// newBlobName 隨機生成加密檔案的名稱，使檔案名稱不會洩露。
+func newBlobName() string {
+	b := make([]byte, 16)
+	lo.Must(rand.Read(b))
+	return hex.EncodeToString(b)
+}
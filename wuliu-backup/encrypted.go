@@ -0,0 +1,401 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/ahui2016/wuliu/util"
+	"github.com/samber/lo"
+	bolt "go.etcd.io/bbolt"
+)
+
+// 加密目標專案的結構:
+//
+//	wuliu_encrypted.json  密鑰參數 (不加密)
+//	index.enc             加密的索引，包括全部檔案的 metadata
+//	files/                加密的檔案，檔案名稱是隨機生成的
+//
+// 加密目標專案沒有 project.json 與數據庫，備份時通過索引判斷需要同步的檔案。
+
+// EncryptedEntry 是加密索引中的一項。
+type EncryptedEntry struct {
+	File *File  // metadata
+	Blob string // files 資料夾中的加密檔案名稱
+}
+
+type EncryptedIndex struct {
+	ProjectName  string
+	LastBackupAt string
+	Files        map[string]*EncryptedEntry // id => entry
+}
+
+// indexSaveInterval 是加密檔案時保存索引的間隔，
+// 中斷後再次執行時，已保存到索引中的檔案不需要重新加密。
+// 每次保存都要重新加密整個索引，因此按時間而不是按檔案數量保存。
+const indexSaveInterval = 30 * time.Second
+
+// createEncryptedProject 把一個空資料夾初始化為加密目標專案。
+func createEncryptedProject(bkRoot string) {
+	passphrase, err := readNewPassphrase()
+	util.PrintErrorExit(err)
+	h, key, err := newEncryptedHeader(MainProjInfo.ProjectName, passphrase)
+	util.PrintErrorExit(err)
+
+	filesDir := filepath.Join(bkRoot, util.FILES)
+	fmt.Println("Create folder:", filesDir)
+	lo.Must0(util.MkdirIfNotExists(filesDir))
+	headerPath := filepath.Join(bkRoot, EncryptedHeaderPath)
+	fmt.Println("Create", headerPath)
+	_ = lo.Must(util.WriteJSON(h, headerPath))
+	idx := &EncryptedIndex{
+		ProjectName:  MainProjInfo.ProjectName,
+		LastBackupAt: util.Epoch,
+		Files:        make(map[string]*EncryptedEntry),
+	}
+	fmt.Println("Create", filepath.Join(bkRoot, EncryptedIndexPath))
+	lo.Must0(idx.save(bkRoot, key))
+	encryptionKeys[bkRoot] = key
+}
+
+func readEncryptedIndex(bkRoot string, key []byte) (*EncryptedIndex, error) {
+	idx := new(EncryptedIndex)
+	err := readEncryptedJSON(idx, filepath.Join(bkRoot, EncryptedIndexPath), key)
+	if idx.Files == nil {
+		idx.Files = make(map[string]*EncryptedEntry)
+	}
+	return idx, err
+}
+
+func (idx *EncryptedIndex) save(bkRoot string, key []byte) error {
+	return writeEncryptedJSON(idx, filepath.Join(bkRoot, EncryptedIndexPath), key)
+}
+
+func (idx *EncryptedIndex) totalSize() (size int64) {
+	for _, e := range idx.Files {
+		size += e.File.Size
+	}
+	return
+}
+
+// findByChecksum 通過 id 或 checksum 尋找可用的加密檔案。
+func (idx *EncryptedIndex) findByChecksum(id, checksum string) *EncryptedEntry {
+	if e, ok := idx.Files[id]; ok && e.File.HasChecksum(checksum) {
+		return e
+	}
+	for _, e := range idx.Files {
+		if e.File.HasChecksum(checksum) {
+			return e
+		}
+	}
+	return nil
+}
+
+// findMeta 返回一個在索引中尋找有用 metadata 的函數 (見 fixMetadata)。
+func (idx *EncryptedIndex) findMeta(bkRoot string) findMetaFunc {
+	return func(root, name string) (*File, string, error) {
+		e, ok := idx.Files[util.NameToID(name)]
+		if !ok {
+			return nil, "", nil
+		}
+		f := *e.File
+		ok, err := goodMetadata(root, name, &f)
+		if err != nil || !ok {
+			return nil, "", err
+		}
+		return &f, bkRoot + " (加密索引)", nil
+	}
+}
+
+func blobPath(bkRoot, blob string) string {
+	return filepath.Join(bkRoot, util.FILES, blob)
+}
+
+// backupToEncrypted 與 backupTo 相同，但目標專案是加密目標專案。
+func backupToEncrypted(n int, danger, fix bool) (synced int, err error) {
+	bkRoot, err := getBkRoot(n)
+	if err != nil {
+		return
+	}
+	key, err := getEncryptionKey(bkRoot)
+	if err != nil {
+		return
+	}
+	idx, err := readEncryptedIndex(bkRoot, key)
+	if err != nil {
+		return
+	}
+	mainDB, err := util.OpenDB(".")
+	if err != nil {
+		return
+	}
+	defer mainDB.Close()
+
+	if *verifyFlag {
+		fmt.Println("加密目標專案不支持 -verify, 跳過檢查 =>", bkRoot)
+	}
+	mainStatus, err := getProjectStatus(".", mainDB)
+	if err != nil {
+		return
+	}
+	bkStatus := ProjectStatus{
+		ProjectInfo: &ProjectInfo{ProjectName: idx.ProjectName, IsBackup: true},
+		Root:        bkRoot,
+		TotalSize:   idx.totalSize(),
+		FilesCount:  len(idx.Files),
+	}
+	printStatus(mainStatus, bkStatus, n)
+	if err = checkStatus(mainStatus, bkStatus, fix); err != nil {
+		return
+	}
+	changed, err := getEncryptedChanges(idx, mainDB)
+	if err != nil {
+		return
+	}
+	changed.BkRoot = bkRoot
+	printChangedFiles(changed)
+
+	if danger {
+		fmt.Printf("備份開始 (加密)\n")
+		synced = changed.Count()
+		if err = syncEncrypted(changed, idx, key); err != nil {
+			return
+		}
+		err = syncMainProjInfo(n)
+		fmt.Printf("備份結束\n\n")
+		return
+	}
+
+	if fix {
+		fmt.Printf("\n嘗試自動修復受損檔案...\n")
+		err = fixFromEncrypted(bkRoot, idx, key, mainDB)
+	}
+	return
+}
+
+// getEncryptedChanges 比較主專案的數據庫與加密索引，找出需要同步的檔案。
+func getEncryptedChanges(idx *EncryptedIndex, mainDB *bolt.DB) (files ChangedFiles, err error) {
+	files.MainRoot = "."
+	deletedSums := make(map[string]string) // 已被刪除的檔案: filename => checksum
+	addedNames := make(map[string]string)  // 新增的檔案: id => filename
+
+	for id, e := range idx.Files {
+		mainFile, err := getFileByID(id, mainDB)
+		if err != nil {
+			return files, err
+		}
+		switch {
+		case mainFile == nil:
+			deletedSums[e.File.Filename] = e.File.Checksum
+		case !util.SameContent(mainFile, e.File):
+			files.Overwrited = append(files.Overwrited, e.File.Filename)
+		case mainFile.UTime != e.File.UTime ||
+			!slices.Equal(mainFile.AllChecksums(), e.File.AllChecksums()):
+			files.Updated = append(files.Updated, e.File.Filename)
+		}
+	}
+
+	err = mainDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(util.FilesBucket)
+		return b.ForEach(func(k, v []byte) error {
+			if _, ok := idx.Files[string(k)]; !ok {
+				mainFile, err := unmarshalFile(v)
+				if err != nil {
+					return err
+				}
+				addedNames[mainFile.ID] = mainFile.Filename
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return
+	}
+	err = files.findRenamed(deletedSums, addedNames, mainDB)
+	return
+}
+
+// syncEncrypted 把主專案的檔案加密後同步到加密目標專案。
+// 被覆蓋或刪除的加密檔案，要等索引保存後纔刪除，因此中斷也不會破壞索引。
+func syncEncrypted(files ChangedFiles, idx *EncryptedIndex, key []byte) error {
+	bkRoot := files.BkRoot
+	if err := removeOrphanBlobs(bkRoot, idx); err != nil {
+		return err
+	}
+	var obsolete []string // 等待刪除的加密檔案
+	flush := func() error {
+		if err := idx.save(bkRoot, key); err != nil {
+			return err
+		}
+		for _, blob := range obsolete {
+			if err := removeIfExists(blobPath(bkRoot, blob)); err != nil {
+				return err
+			}
+		}
+		obsolete = nil
+		return nil
+	}
+
+	for i, r := range files.Renamed {
+		printProgress("改名", i+1, len(files.Renamed))
+		oldID := util.NameToID(r.OldName)
+		mainFile := readMainFile(r.NewName)
+		idx.Files[mainFile.ID] = &EncryptedEntry{File: &mainFile, Blob: idx.Files[oldID].Blob}
+		delete(idx.Files, oldID)
+	}
+	for i, name := range files.Deleted {
+		printProgress("刪除", i+1, len(files.Deleted))
+		id := util.NameToID(name)
+		obsolete = append(obsolete, idx.Files[id].Blob)
+		delete(idx.Files, id)
+	}
+	for i, name := range files.Updated {
+		printProgress("屬性", i+1, len(files.Updated))
+		mainFile := readMainFile(name)
+		idx.Files[mainFile.ID].File = &mainFile
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	toEncrypt := append(slices.Clone(files.Overwrited), files.Added...)
+	tmp := filepath.Join(bkRoot, BackupTempPath)
+	lastFlush := time.Now()
+	for i, name := range toEncrypt {
+		printProgress("加密", i+1, len(toEncrypt))
+		mainFile := readMainFile(name)
+		blob := newBlobName()
+		src := filepath.Join(util.FILES, name)
+		if err := encryptFile(blobPath(bkRoot, blob), src, tmp, mainFile.Checksum, key); err != nil {
+			return err
+		}
+		if old, ok := idx.Files[mainFile.ID]; ok {
+			obsolete = append(obsolete, old.Blob)
+		}
+		idx.Files[mainFile.ID] = &EncryptedEntry{File: &mainFile, Blob: blob}
+		if time.Since(lastFlush) > indexSaveInterval {
+			if err := flush(); err != nil {
+				return err
+			}
+			lastFlush = time.Now()
+		}
+	}
+	idx.LastBackupAt = util.Now()
+	return flush()
+}
+
+// removeOrphanBlobs 刪除不在索引中的加密檔案 (上次備份中斷時留下的)。
+func removeOrphanBlobs(bkRoot string, idx *EncryptedIndex) error {
+	blobs := make(map[string]bool)
+	for _, e := range idx.Files {
+		blobs[e.Blob] = true
+	}
+	names, err := util.GetFilenamesBase(filepath.Join(bkRoot, util.FILES))
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !blobs[name] {
+			fmt.Println("Delete orphan =>", blobPath(bkRoot, name))
+			if err := os.Remove(blobPath(bkRoot, name)); err != nil {
+				return err
+			}
+		}
+	}
+	return removeIfExists(filepath.Join(bkRoot, BackupTempPath))
+}
+
+func readMainFile(name string) File {
+	return util.ReadFile(filepath.Join(util.METADATA, name+".json"))
+}
+
+// syncMainProjInfo 只更新主專案的上次備份時間 (加密目標專案沒有 project.json).
+func syncMainProjInfo(n int) error {
+	now := util.Now()
+	MainProjInfo.LastBackupAt[0] = now
+	MainProjInfo.LastBackupAt[n] = now
+	fmt.Println("Update =>", util.ProjectInfoPath)
+	return util.WriteProjectInfo(MainProjInfo)
+}
+
+// fixFromEncrypted 從加密目標專案中解密檔案 (或採用索引中的 metadata),
+// 修復主專案中的受損檔案與受損 metadata.
+func fixFromEncrypted(bkRoot string, idx *EncryptedIndex, key []byte, mainDB *bolt.DB) error {
+	fcMap, err := util.ReadFileChecked(".")
+	if err != nil {
+		return err
+	}
+	changed, err := fixMetadata(".", fcMap, mainDB, idx.findMeta(bkRoot))
+	if err != nil {
+		return err
+	}
+	ids := util.DamagedOfFileChecked(fcMap)
+	if len(ids) == 0 {
+		fmt.Println("無受損檔案 =>", ".")
+	}
+	damagedFiles, err := getFilesByIDs(ids, mainDB)
+	if err != nil {
+		return err
+	}
+	for _, f := range damagedFiles {
+		dst := filepath.Join(util.FILES, f.Filename)
+		e := idx.findByChecksum(f.ID, f.Checksum)
+		if e == nil {
+			fmt.Println("未修復 =>", dst)
+			continue
+		}
+		fmt.Println("發現有用檔案 =>", blobPath(bkRoot, e.Blob))
+		fmt.Println("自動修復 =>", dst)
+		if err := decryptFile(dst, blobPath(bkRoot, e.Blob), BackupTempPath, f.Checksum, key); err != nil {
+			return err
+		}
+		if err := util.SetMTime(dst, f); err != nil {
+			return err
+		}
+		fcMap[f.ID].Damaged = false
+		changed = true
+	}
+	if changed {
+		fmt.Println("Update =>", util.FileCheckedPath)
+		_, err := util.WriteJSON(fcMap, util.FileCheckedPath)
+		return err
+	}
+	return nil
+}
+
+// decryptToBuffer 從加密目標專案中解密一個檔案及其 metadata 到主專案的 buffer 資料夾。
+func decryptToBuffer(bkRoot, id string) error {
+	if !isEncrypted(bkRoot) {
+		log.Fatalln("不是加密目標專案:", bkRoot)
+	}
+	key, err := getEncryptionKey(bkRoot)
+	if err != nil {
+		return err
+	}
+	idx, err := readEncryptedIndex(bkRoot, key)
+	if err != nil {
+		return err
+	}
+	e, ok := idx.Files[id]
+	if !ok {
+		return fmt.Errorf("Not Found: %s", id)
+	}
+	dstFile := filepath.Join(util.BUFFER, e.File.Filename)
+	dstMeta := filepath.Join(util.BUFFER, e.File.Filename+".json")
+	for _, dst := range []string{dstFile, dstMeta} {
+		if util.PathExists(dst) {
+			return fmt.Errorf("file exists: %s", dst)
+		}
+	}
+	fmt.Println("Decrypt =>", dstFile)
+	tmp := filepath.Join(util.BUFFER, BackupTempPath)
+	if err := decryptFile(dstFile, blobPath(bkRoot, e.Blob), tmp, e.File.Checksum, key); err != nil {
+		return err
+	}
+	fmt.Println("Decrypt =>", dstMeta)
+	_, err = util.WriteJSON(e.File, dstMeta)
+	return err
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ahui2016/wuliu/util"
+	bolt "go.etcd.io/bbolt"
+)
+
+// findMetaFunc 為專案 root 中檔案 name 的受損 metadata 尋找有用的副本,
+// 返回副本及其來源 (用於列印), 找不到時返回 nil.
+type findMetaFunc func(root, name string) (f *File, from string, err error)
+
+// fixMetadata 修復 root 中已受損的 metadata (由 `wuliu-checksum -meta` 標記),
+// 並更新數據庫。如果 changed==true, 說明 fcMap 的内容已改變。
+func fixMetadata(root string, fcMap map[string]*FileChecked, db *bolt.DB, find findMetaFunc) (changed bool, err error) {
+	ids := util.MetaDamagedOfFileChecked(fcMap)
+	if len(ids) == 0 {
+		fmt.Println("無受損 metadata =>", root)
+		return false, nil
+	}
+	names, err := util.IdsToNames(ids, db)
+	if err != nil {
+		return false, err
+	}
+	for i, id := range ids {
+		dst := filepath.Join(root, util.METADATA, names[i]+".json")
+		f, from, err := find(root, names[i])
+		if err != nil {
+			return false, err
+		}
+		if f == nil {
+			fmt.Println("未修復 =>", dst)
+			continue
+		}
+		fmt.Println("發現有用 metadata =>", from)
+		fmt.Println("自動修復 =>", dst)
+		if err := rewriteMetadata(dst, f, db); err != nil {
+			return false, err
+		}
+		fcMap[id].MetaDamaged = false
+		changed = true
+	}
+	return
+}
+
+// rewriteMetadata 把 f 寫入 metadata 檔案 dst 與數據庫 (包括全部索引)。
+func rewriteMetadata(dst string, f *File, db *bolt.DB) error {
+	data, err := util.WriteJSON(f, dst)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(util.FilesBucket)
+		old, err := util.GetFileInBucket(f.ID, b)
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(f.ID), data); err != nil {
+			return err
+		}
+		return util.UpdateIndexes(&old, f, tx)
+	})
+}
+
+// goodMetadata 判斷 f 能否作為 root 中檔案 name 的 metadata:
+// 內容與實際檔案一致，並且 checksum 與實際檔案一致。
+func goodMetadata(root, name string, f *File) (bool, error) {
+	if len(util.CheckMetaAttrs(root, name, f)) > 0 {
+		return false, nil
+	}
+	return util.VerifyChecksum(filepath.Join(root, util.FILES, name), f.Checksum)
+}
+
+// findMetaInProjects 在其他已登記的專案中尋找有用的 metadata.
+// 無法訪問的專案與加密目標專案會被跳過。
+func findMetaInProjects(root, name string) (*File, string, error) {
+	roots, err := projectRoots(root)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, r := range roots[1:] {
+		metaPath := filepath.Join(r, util.METADATA, name+".json")
+		data, err := os.ReadFile(metaPath)
+		if err != nil {
+			continue
+		}
+		f, err := util.ParseMetadata(data)
+		if err != nil {
+			continue
+		}
+		ok, err := goodMetadata(root, name, &f)
+		if err != nil {
+			return nil, "", err
+		}
+		if ok {
+			return &f, metaPath, nil
+		}
+	}
+	return nil, "", nil
+}
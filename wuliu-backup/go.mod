@@ -3,3 +3,7 @@ module github.com/ahui2016/wuliu/wuliu-backup
 go 1.21.0
 
 require github.com/juju/utils/v4 v4.0.0 // indirect
+
+require golang.org/x/term v0.16.0
+
+require golang.org/x/sys v0.16.0 // indirect
@@ -11,17 +11,39 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	BackupProgressPath = "backup_progress.txt" // 在目標專案根目錄中，記錄已複製完成的檔案
+	BackupTempPath     = "backup_copying.tmp"  // 在目標專案根目錄中，正在複製的臨時檔案
 )
 
 var (
-	MainProjInfo = util.ReadProjectInfo(".")
+	MainProjInfo ProjectInfo
 )
 
 var (
-	projectsFlag = flag.Bool("projects", false, "list all projects")
-	nFlag        = flag.Int("n", 0, "select a project by a number")
-	dangerFlag   = flag.Bool("danger", false, "do backup files")
-	fixFlag      = flag.Bool("fix", false, "try to fix files automatically")
+	projectsFlag   = flag.Bool("projects", false, "list all projects")
+	nFlag          = flag.Int("n", 0, "select a project by a number")
+	allFlag        = flag.Bool("all", false, "backup to all projects one by one")
+	versionedFlag  = flag.String("versioned", "", "on/off: keep overwritten and deleted files in snapshots")
+	snapshotsFlag  = flag.Bool("snapshots", false, "list all snapshots in the backup project")
+	restoreFlag    = flag.String("restore", "", "copy a file from a snapshot to buffer, e.g. -restore SNAPSHOT/FILENAME")
+	createFlag     = flag.String("create", "", "create a backup project in an empty folder")
+	encryptFlag    = flag.Bool("encrypt", false, "use with '-create' to create an encrypted backup project")
+	decryptFlag    = flag.String("decrypt", "", "decrypt a file (by ID) from an encrypted backup project to buffer")
+	archiveFlag    = flag.String("archive", "", "create a tar archive in the folder")
+	fullFlag       = flag.Bool("full", false, "use with '-archive' to create a full archive")
+	archivesFlag   = flag.String("archives", "", "list all archives in the folder")
+	unarchiveFlag  = flag.String("unarchive", "", "rebuild a project from the archives in the folder")
+	toFlag         = flag.String("to", "", "use with '-unarchive' to specify an empty folder")
+	promoteFlag    = flag.Bool("promote", false, "turn the current backup project into the main project")
+	verifyFlag     = flag.Bool("verify", false, "check some files in the backup project before backup")
+	verifySizeFlag = flag.Int("verify-size", 0, "use with '-verify', check files up to this size in MB (default: CheckSizeLimit)")
+	dangerFlag     = flag.Bool("danger", false, "do backup files")
+	fixFlag        = flag.Bool("fix", false, "try to fix files automatically")
 )
 
 type (
@@ -33,95 +55,353 @@ type (
 
 func main() {
 	flag.Parse()
+
+	if *archivesFlag != "" {
+		util.PrintErrorExit(printArchives(*archivesFlag))
+		return
+	}
+
+	if *unarchiveFlag != "" {
+		if *toFlag == "" {
+			log.Fatalln("請使用參數 '-to' 指定一個空資料夾")
+		}
+		util.PrintErrorExit(unarchive(*unarchiveFlag, *toFlag, *dangerFlag))
+		return
+	}
+
 	util.MustInWuliu()
+	MainProjInfo = util.ReadProjectInfo(".")
 
 	if *projectsFlag {
 		printProjectsList()
 		return
 	}
 
-	var (
-		bkRoot       string
-		mainDB, bkDB *bolt.DB
-	)
+	if *promoteFlag {
+		promote(*dangerFlag)
+		return
+	}
+
+	if *archiveFlag != "" {
+		util.CheckNotAllowInBackup()
+		util.PrintErrorExit(createArchive(*archiveFlag, *fullFlag, *dangerFlag))
+		return
+	}
 
-	if *nFlag > 0 {
-		bkRoot = getBkRoot()
-		mainDB = lo.Must(util.OpenDB("."))
-		defer mainDB.Close()
-		bkDB = lo.Must(util.OpenDB(bkRoot))
-		defer bkDB.Close()
+	if *createFlag != "" {
+		util.CheckNotAllowInBackup()
+		*nFlag = createBackupProject(*createFlag)
+		*dangerFlag = true
+	}
 
-		mainStatus, bkStatus := getProjectsStatus(".", bkRoot, mainDB, bkDB)
-		printStatus(mainStatus, bkStatus, *nFlag)
-		if err := checkStatus(mainStatus, bkStatus, *fixFlag); err != nil {
-			fmt.Println("Error!", err)
+	if *versionedFlag != "" {
+		setVersioned(mustGetBkRoot(*nFlag), *versionedFlag)
+		if *createFlag == "" {
 			return
 		}
 	}
 
-	if *dangerFlag || *fixFlag {
-		if *nFlag < 1 {
+	if *snapshotsFlag {
+		lo.Must0(printSnapshots(mustGetBkRoot(*nFlag)))
+		return
+	}
+
+	if *decryptFlag != "" {
+		err := decryptToBuffer(mustGetBkRoot(*nFlag), *decryptFlag)
+		util.PrintErrorExit(err)
+		return
+	}
+
+	if *restoreFlag != "" {
+		err := restoreFromSnapshot(mustGetBkRoot(*nFlag), *restoreFlag)
+		util.PrintErrorExit(err)
+		return
+	}
+
+	if *allFlag {
+		if *nFlag > 0 {
+			log.Fatalln("不可同時使用參數 '-all' 與 '-n'")
+		}
+		backupToAll(*dangerFlag, *fixFlag)
+		return
+	}
+
+	if *nFlag < 1 {
+		if *dangerFlag || *fixFlag {
 			fmt.Println("請使用參數 '-n' 指定目標專案")
+		}
+		return
+	}
+	if _, err := backupTo(*nFlag, *dangerFlag, *fixFlag); err != nil {
+		fmt.Println("Error!", err)
+	}
+}
+
+// BackupResult 是備份到一個目標專案的結果，用於 -all 模式最後的匯總表。
+type BackupResult struct {
+	N      int
+	Root   string
+	Result string // 跳過, 失敗, 預覽, 已備份, 自動修復
+	Synced int    // 已同步的檔案數量
+	Err    error
+}
+
+// backupToAll 依次備份到全部目標專案，無法訪問的目標專案 (例如未插入的硬碟) 會被跳過,
+// 最後列印全部目標專案的結果。
+func backupToAll(danger, fix bool) {
+	n := len(MainProjInfo.Projects)
+	if n < 2 {
+		printProjectsList()
+		return
+	}
+	var results []BackupResult
+	for i := 1; i < n; i++ {
+		bkRoot := MainProjInfo.Projects[i]
+		fmt.Printf("\n[%d/%d] 目標專案 => %s\n", i, n-1, bkRoot)
+		r := BackupResult{N: i, Root: bkRoot}
+		if err := checkReachable(bkRoot); err != nil {
+			fmt.Println("跳過:", err)
+			r.Result = "跳過"
+			r.Err = err
+			results = append(results, r)
+			continue
+		}
+		synced, err := backupTo(i, danger, fix)
+		r.Synced = synced
+		r.Err = err
+		switch {
+		case err != nil:
+			fmt.Println("Error!", err)
+			r.Result = "失敗"
+		case danger:
+			r.Result = "已備份"
+		case fix:
+			r.Result = "自動修復"
+		default:
+			r.Result = "預覽"
+		}
+		results = append(results, r)
+	}
+	printResults(results)
+}
+
+func printResults(results []BackupResult) {
+	fmt.Printf("\n結果匯總:\n")
+	fmt.Printf("序號\t結果\t同步\t目標專案\n")
+	for _, r := range results {
+		fmt.Printf("%d\t%s\t%d\t%s\n", r.N, r.Result, r.Synced, r.Root)
+		if r.Err != nil {
+			fmt.Printf("\t(%s)\n", strings.TrimSpace(r.Err.Error()))
+		}
+	}
+	fmt.Println()
+}
+
+// checkReachable 檢查目標專案能否訪問 (例如外置硬碟是否已插入)。
+func checkReachable(bkRoot string) error {
+	names := []string{util.ProjectInfoPath, util.DatabasePath}
+	if isEncrypted(bkRoot) {
+		names = []string{EncryptedIndexPath, util.FILES}
+	}
+	for _, name := range names {
+		fullpath := filepath.Join(bkRoot, name)
+		if util.PathNotExists(fullpath) {
+			return fmt.Errorf("找不到 %s", fullpath)
+		}
+	}
+	return nil
+}
+
+// backupTo 檢查並列印主專案與第 n 個目標專案的狀態，
+// 如果 danger 為 true 則執行備份, 否則如果 fix 為 true 則嘗試自動修復受損檔案。
+// 返回已同步的檔案數量。
+func backupTo(n int, danger, fix bool) (synced int, err error) {
+	bkRoot, err := getBkRoot(n)
+	if err != nil {
+		return
+	}
+	if err = checkReachable(bkRoot); err != nil {
+		return
+	}
+	if isEncrypted(bkRoot) {
+		return backupToEncrypted(n, danger, fix)
+	}
+	mainDB, err := util.OpenDB(".")
+	if err != nil {
+		return
+	}
+	defer mainDB.Close()
+	bkDB, err := util.OpenDB(bkRoot)
+	if err != nil {
+		return
+	}
+	defer bkDB.Close()
+
+	if *verifyFlag {
+		if err = verifyBackup(bkRoot, bkDB); err != nil {
 			return
 		}
 	}
 
-	if *dangerFlag {
+	mainStatus, bkStatus, err := getProjectsStatus(".", bkRoot, mainDB, bkDB)
+	if err != nil {
+		return
+	}
+	printStatus(mainStatus, bkStatus, n)
+	if err = checkStatus(mainStatus, bkStatus, fix); err != nil {
+		return
+	}
+	changed, fullScan, err := compareProjects(".", bkRoot, mainDB, bkDB)
+	if err != nil {
+		return
+	}
+	printChangedFiles(changed)
+
+	if danger {
 		fmt.Printf("備份開始\n")
-		lo.Must0(syncProjInfo(bkRoot, *nFlag))
-		n, err := syncFilesToBK(".", bkRoot, mainDB, bkDB)
-		util.PrintErrorExit(err)
-		if n > 0 {
+		if slices.Contains(MainProjInfo.VersionedProjects, bkRoot) {
+			changed.Snapshot = newSnapshotFolder(bkRoot)
+			fmt.Println("保留舊版本 =>", changed.Snapshot)
+		}
+		if err = syncProjInfo(bkRoot, n); err != nil {
+			return
+		}
+		synced, err = syncFilesToBK(changed)
+		if err != nil {
+			return
+		}
+		// 沒有 Merkle tree 的舊數據庫也需要重建。
+		if synced > 0 || fullScan {
 			fmt.Println()
-			rebuildDatabase(bkRoot, mainDB, bkDB)
+			if err = rebuildDatabase(bkRoot, mainDB, bkDB); err != nil {
+				return
+			}
 		}
 		fmt.Printf("備份結束\n\n")
 		return
 	}
 
-	if *fixFlag {
+	if fix {
 		fmt.Printf("\n嘗試自動修復受損檔案...\n")
-		lo.Must0(autoFix(".", bkRoot, mainDB, bkDB))
-		return
+		err = autoFix(".", bkRoot, mainDB, bkDB)
+	}
+	return
+}
+
+// createBackupProject 把一個空資料夾初始化為備份專案，並添加到主專案的 Projects 中,
+// 返回新備份專案的序號。
+// 備份專案以絕對路徑登記，因為備份專案的 project.json 也會記錄 Projects,
+// 在備份專案中執行 (例如 -promote) 時相對路徑無法正確解析。
+func createBackupProject(bkRoot string) int {
+	bkRoot = lo.Must(filepath.Abs(bkRoot))
+	if slices.Contains(lo.Must(absProjects(MainProjInfo.Projects)), bkRoot) {
+		log.Fatalln("已存在於 Projects 中:", bkRoot)
+	}
+	lo.Must0(os.MkdirAll(bkRoot, util.NormalDirPerm))
+	util.FolderMustEmpty(bkRoot)
+
+	fmt.Println("創建備份專案 =>", bkRoot)
+	if *encryptFlag {
+		createEncryptedProject(bkRoot)
+	} else {
+		util.MakeFolders(bkRoot, true)
+		util.InitFileChecked(bkRoot)
+		util.CreateDatabase(bkRoot)
+		bkProjInfo := lo.Must(backupProjInfo(MainProjInfo))
+		bkProjInfoPath := filepath.Join(bkRoot, util.ProjectInfoPath)
+		fmt.Println("Create", bkProjInfoPath)
+		_ = lo.Must(util.WriteJSON(bkProjInfo, bkProjInfoPath))
 	}
+
+	MainProjInfo.Projects = append(MainProjInfo.Projects, bkRoot)
+	MainProjInfo.LastBackupAt = append(MainProjInfo.LastBackupAt, util.Epoch)
+	fmt.Println("Update =>", util.ProjectInfoPath)
+	lo.Must0(util.WriteProjectInfo(MainProjInfo))
+	return len(MainProjInfo.Projects) - 1
 }
 
-func rebuildDatabase(bkRoot string, mainDB, bkDB *bolt.DB) {
+func rebuildDatabase(bkRoot string, mainDB, bkDB *bolt.DB) error {
 	// fmt.Printf("\nRebuild database...\n")
-	lo.Must0(util.RebuildSomeBuckets(mainDB))
+	if err := util.RebuildSomeBuckets(mainDB); err != nil {
+		return err
+	}
 	bkDB.Close()
-	util.RebuildDatabase(bkRoot)
+	return util.RecreateDatabase(bkRoot)
 }
 
-func getBkRoot() string {
-	if *nFlag == 0 {
-		log.Fatalln("請使用參數 '-n' 指定備份專案")
+func getBkRoot(n int) (string, error) {
+	if n == 0 {
+		return "", fmt.Errorf("請使用參數 '-n' 指定備份專案")
+	}
+	if n < 0 || n >= len(MainProjInfo.Projects) {
+		return "", fmt.Errorf("備份專案序號超出範圍: %d", n)
 	}
-	return MainProjInfo.Projects[*nFlag]
+	return MainProjInfo.Projects[n], nil
 }
 
-func getProjectsStatus(mainRoot, bkRoot string, mainDB, bkDB *bolt.DB) (mainStatus, bkStatus ProjectStatus) {
-	mainProjInfo := util.ReadProjectInfo(mainRoot)
-	fileN, totalSize := lo.Must2(util.DatabaseFilesSize(mainDB))
-	fcMap := lo.Must(util.ReadFileChecked("."))
-	damagedFiles := util.DamagedOfFileChecked(fcMap)
-	mainStatus.ProjectInfo = &mainProjInfo
-	mainStatus.Root = "."
-	mainStatus.TotalSize = totalSize
-	mainStatus.FilesCount = fileN
-	mainStatus.DamagedCount = len(damagedFiles)
-
-	bkProjInfo := util.ReadProjectInfo(bkRoot)
-	fileN, totalSize = lo.Must2(util.DatabaseFilesSize(bkDB))
-	fcMap = lo.Must(util.ReadFileChecked(bkRoot))
-	damagedFiles = util.DamagedOfFileChecked(fcMap)
-	bkStatus.ProjectInfo = &bkProjInfo
-	bkStatus.Root = bkRoot
-	bkStatus.TotalSize = totalSize
-	bkStatus.FilesCount = fileN
-	bkStatus.DamagedCount = len(damagedFiles)
+func mustGetBkRoot(n int) string {
+	bkRoot, err := getBkRoot(n)
+	util.PrintErrorExit(err)
+	return bkRoot
+}
+
+// verifyBackup 檢查目標專案中的一部分檔案 (上次檢查時間超過週期的檔案),
+// 檢查的檔案體積合計以 CheckSizeLimit (或參數 -verify-size) 為上限，
+// 並更新目標專案的 file_checked.json.
+func verifyBackup(bkRoot string, bkDB *bolt.DB) error {
+	fcMap, err := util.ReadFileChecked(bkRoot)
+	if err != nil {
+		return err
+	}
+	if fcMap, err = util.ReconcileFileCheckedMap(fcMap, bkDB); err != nil {
+		return err
+	}
+	opts := util.NewCheckOptions(MainProjInfo)
+	if *verifySizeFlag > 0 {
+		opts.SizeLimit = int64(*verifySizeFlag) * util.MB
+	}
+	fmt.Printf("\n檢查目標專案 => %s\n", bkRoot)
+	checkN, checkedSize, err := util.CheckFiles(bkRoot, fcMap, opts, bkDB)
+	if err != nil {
+		return err
+	}
+	fmt.Println("本次檢查檔案數量:", checkN)
+	fmt.Println("本次檢查檔案體積:", util.FileSizeToString(float64(checkedSize), 2))
+	fileCheckedPath := filepath.Join(bkRoot, util.FileCheckedPath)
+	fmt.Println("Update =>", fileCheckedPath)
+	_, err = util.WriteJSON(fcMap, fileCheckedPath)
+	return err
+}
 
+func getProjectsStatus(mainRoot, bkRoot string, mainDB, bkDB *bolt.DB) (mainStatus, bkStatus ProjectStatus, err error) {
+	if mainStatus, err = getProjectStatus(mainRoot, mainDB); err != nil {
+		return
+	}
+	bkStatus, err = getProjectStatus(bkRoot, bkDB)
+	return
+}
+
+func getProjectStatus(root string, db *bolt.DB) (status ProjectStatus, err error) {
+	projInfo, err := util.LoadProjectInfo(root)
+	if err != nil {
+		return
+	}
+	fileN, totalSize, err := util.DatabaseFilesSize(db)
+	if err != nil {
+		return
+	}
+	fcMap, err := util.ReadFileChecked(root)
+	if err != nil {
+		return
+	}
+	damagedFiles := util.DamagedOfFileChecked(fcMap)
+	metaDamaged := util.MetaDamagedOfFileChecked(fcMap)
+	status.ProjectInfo = &projInfo
+	status.Root = root
+	status.TotalSize = totalSize
+	status.FilesCount = fileN
+	status.DamagedCount = len(damagedFiles)
+	status.MetaDamagedCount = len(metaDamaged)
 	return
 }
 
@@ -129,11 +409,12 @@ func printProjectsList() {
 	bkProjects := MainProjInfo.Projects[1:]
 	if len(bkProjects) == 0 {
 		fmt.Println("無備份專案。")
-		fmt.Println("添加備份專案的方法請參閱", util.RepoURL)
+		fmt.Println("可使用 `wuliu-backup -create [PATH]` 創建備份專案，詳情請參閱", util.RepoURL)
 		return
 	}
 	for i, project := range bkProjects {
-		fmt.Printf("%d %s\n", i+1, project)
+		encrypted := lo.Ternary(isEncrypted(project), " (加密)", "")
+		fmt.Printf("%d %s%s\n", i+1, project, encrypted)
 	}
 }
 
@@ -146,14 +427,48 @@ func syncProjInfo(bkRoot string, n int) error {
 		return err
 	}
 
-	bkProjInfo := MainProjInfo
-	bkProjInfo.IsBackup = true
+	bkProjInfo, err := backupProjInfo(MainProjInfo)
+	if err != nil {
+		return err
+	}
 	bkProjInfoPath := filepath.Join(bkRoot, util.ProjectInfoPath)
 	fmt.Println("Update =>", bkProjInfoPath)
-	_, err := util.WriteJSON(bkProjInfo, bkProjInfoPath)
+	_, err = util.WriteJSON(bkProjInfo, bkProjInfoPath)
 	return err
 }
 
+// backupProjInfo 返回寫入備份專案的 project.json 內容。
+// Projects 與 VersionedProjects 中的備份專案轉為絕對路徑 (見 absProjects),
+// 以便在備份專案中執行 -promote 等操作。必須在主專案中執行。
+func backupProjInfo(info ProjectInfo) (bkInfo ProjectInfo, err error) {
+	bkInfo = info
+	bkInfo.IsBackup = true
+	if bkInfo.Projects, err = absProjects(info.Projects); err != nil {
+		return
+	}
+	bkInfo.VersionedProjects = nil
+	for _, project := range info.VersionedProjects {
+		abs, err := filepath.Abs(project)
+		if err != nil {
+			return bkInfo, err
+		}
+		bkInfo.VersionedProjects = append(bkInfo.VersionedProjects, abs)
+	}
+	return
+}
+
+// absProjects 把 Projects 中的備份專案 (相對於主專案) 轉為絕對路徑，
+// 第一項 (主專案本身, 即 ".") 保持不變。必須在主專案中執行。
+func absProjects(projects []string) (abs []string, err error) {
+	abs = slices.Clone(projects)
+	for i := 1; i < len(abs); i++ {
+		if abs[i], err = filepath.Abs(abs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
 // 检查 ProjectName 相同，检查 IsBakcup == true, 列印两个数据库的档案数量、
 // 上次备份日期、损坏档案，有损坏档案禁止备份。
 func checkStatus(mainStatus, bkStatus ProjectStatus, fix bool) error {
@@ -167,6 +482,9 @@ func checkStatus(mainStatus, bkStatus ProjectStatus, fix bool) error {
 	if !fix && mainStatus.DamagedCount+bkStatus.DamagedCount > 0 {
 		return fmt.Errorf("發現受損檔案，必須修復後纔能備份。\n")
 	}
+	if !fix && mainStatus.MetaDamagedCount+bkStatus.MetaDamagedCount > 0 {
+		return fmt.Errorf("發現受損 metadata, 必須修復後纔能備份。\n")
+	}
 	sizeDiff := mainStatus.TotalSize - bkStatus.TotalSize
 	return checkBackupDiskUsage(bkStatus.Root, sizeDiff)
 }
@@ -194,6 +512,7 @@ func printStatus(mainStatus, bkStatus ProjectStatus, n int) {
 	fmt.Printf("檔案數量\t%d\n", mainStatus.FilesCount)
 	fmt.Printf("體積合計\t%s\n", totalSize)
 	fmt.Printf("受損檔案\t%d\n", mainStatus.DamagedCount)
+	fmt.Printf("受損metadata\t%d\n", mainStatus.MetaDamagedCount)
 	fmt.Printf("上次備份時間\t%s\n", mainBackupAt)
 	fmt.Println()
 	totalSize = util.FileSizeToString(float64(bkStatus.TotalSize), 2)
@@ -202,6 +521,7 @@ func printStatus(mainStatus, bkStatus ProjectStatus, n int) {
 	fmt.Printf("檔案數量\t%d\n", bkStatus.FilesCount)
 	fmt.Printf("體積合計\t%s\n", totalSize)
 	fmt.Printf("受損檔案\t%d\n", bkStatus.DamagedCount)
+	fmt.Printf("受損metadata\t%d\n", bkStatus.MetaDamagedCount)
 	fmt.Printf("上次備份時間\t%s\n", bkBackupAt)
 	fmt.Println()
 	sizeDiff := mainStatus.TotalSize - bkStatus.TotalSize
@@ -213,30 +533,59 @@ func printStatus(mainStatus, bkStatus ProjectStatus, n int) {
 	fmt.Println()
 }
 
-func syncFilesToBK(mainRoot, bkRoot string, mainDB, bkDB *bolt.DB) (int, error) {
-	files, err := getChangedFiles(mainRoot, bkRoot, mainDB, bkDB)
-	if err != nil {
-		return 0, err
+func printChangedFiles(files ChangedFiles) {
+	fmt.Println("需要同步的檔案:")
+	fmt.Printf("刪除\t%d\n", len(files.Deleted))
+	fmt.Printf("改名\t%d\n", len(files.Renamed))
+	fmt.Printf("屬性\t%d\n", len(files.Updated))
+	fmt.Printf("覆蓋\t%d\n", len(files.Overwrited))
+	fmt.Printf("新增\t%d\n", len(files.Added))
+	for _, r := range files.Renamed {
+		fmt.Printf("改名: %s => %s\n", r.OldName, r.NewName)
 	}
+	fmt.Println()
+}
+
+func syncFilesToBK(files ChangedFiles) (int, error) {
 	n := files.Count()
-	err = files.Sync()
+	err := files.Sync()
 	return n, err
 }
 
 type ChangedFiles struct {
 	MainRoot   string
 	BkRoot     string
+	Done       map[string]string // 上次中斷前已複製完成的檔案: filename => checksum
+	Snapshot   string            // 如果不是空字符串，被覆蓋或刪除的舊檔案會移到這個資料夾
 	Deleted    []string
+	Renamed    []RenamedFile
 	Updated    []string
 	Overwrited []string
 	Added      []string
 }
 
+// RenamedFile 是在主專案中改了名的檔案 (checksum 相同, ID 不同),
+// 備份時只需要在目標專案中改名，不需要重新複製。
+type RenamedFile struct {
+	OldName string
+	NewName string
+}
+
 func (files ChangedFiles) Count() int {
-	return len(files.Deleted) + len(files.Updated) + len(files.Overwrited) + len(files.Added)
+	return len(files.Deleted) + len(files.Renamed) + len(files.Updated) +
+		len(files.Overwrited) + len(files.Added)
 }
 
+// Sync 執行同步。每複製完成一個檔案，都會記錄在目標專案的 backup_progress.txt 中，
+// 如果中途中斷，再次執行時會跳過已完成的檔案。
 func (files ChangedFiles) Sync() (err error) {
+	if files.Done, err = readProgress(files.BkRoot); err != nil {
+		return
+	}
+	if err = files.syncRename(); err != nil {
+		fmt.Println("Error: rename", err)
+		return
+	}
 	if err = files.syncDelete(); err != nil {
 		fmt.Println("Error: delete", err)
 		return
@@ -253,16 +602,14 @@ func (files ChangedFiles) Sync() (err error) {
 		fmt.Println("Error: add", err)
 		return
 	}
-	return nil
+	return removeIfExists(filepath.Join(files.BkRoot, BackupProgressPath))
 }
 
 func (files ChangedFiles) syncDelete() error {
-	for _, name := range files.Deleted {
-		fmt.Print(".")
-		filePath := filepath.Join(files.BkRoot, util.FILES, name)
-		metaPath := filepath.Join(files.BkRoot, util.METADATA, name+".json")
-		e1 := os.Remove(metaPath)
-		e2 := os.Remove(filePath)
+	for i, name := range files.Deleted {
+		printProgress("刪除", i+1, len(files.Deleted))
+		e1 := files.removeOrSnapshot(util.METADATA, name+".json")
+		e2 := files.removeOrSnapshot(util.FILES, name)
 		if err := util.WrapErrors(e1, e2); err != nil {
 			return err
 		}
@@ -270,9 +617,40 @@ func (files ChangedFiles) syncDelete() error {
 	return nil
 }
 
+func (files ChangedFiles) syncRename() error {
+	for i, r := range files.Renamed {
+		printProgress("改名", i+1, len(files.Renamed))
+		oldPath := filepath.Join(files.BkRoot, util.FILES, r.OldName)
+		newPath := filepath.Join(files.BkRoot, util.FILES, r.NewName)
+		oldMeta := filepath.Join(files.BkRoot, util.METADATA, r.OldName+".json")
+		// 如果上次中斷前已改名，則跳過。
+		switch {
+		case util.PathExists(oldPath):
+			if err := os.Rename(oldPath, newPath); err != nil {
+				return err
+			}
+		case util.PathNotExists(newPath):
+			// 目標專案中舊檔案與新檔案都不存在，與新增的檔案一樣從主專案複製。
+			if err := files.overwriteFileAndMeta(r.NewName); err != nil {
+				return err
+			}
+		}
+		if err := removeIfExists(oldMeta); err != nil {
+			return err
+		}
+		if err := overwriteMetadata(r.NewName, files.BkRoot, files.MainRoot); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (files ChangedFiles) syncUpdate() error {
-	for _, name := range files.Updated {
-		fmt.Print(".")
+	for i, name := range files.Updated {
+		printProgress("屬性", i+1, len(files.Updated))
+		if err := files.moveToSnapshot(util.METADATA, name+".json"); err != nil {
+			return err
+		}
 		if err := overwriteMetadata(name, files.BkRoot, files.MainRoot); err != nil {
 			return err
 		}
@@ -283,36 +661,103 @@ func (files ChangedFiles) syncUpdate() error {
 func overwriteMetadata(name, bkRoot, mainRoot string) error {
 	src := filepath.Join(mainRoot, util.METADATA, name+".json")
 	dst := filepath.Join(bkRoot, util.METADATA, name+".json")
-	return util.CopyFile(dst, src)
+	tmp := filepath.Join(bkRoot, BackupTempPath)
+	return util.CopyFileVerified(dst, src, tmp, "")
 }
 
 func (files ChangedFiles) syncOverwrite() error {
-	for _, name := range files.Overwrited {
-		fmt.Print(".")
-		if err := overwriteFileAndMeta(name, files.BkRoot, files.MainRoot); err != nil {
+	for i, name := range files.Overwrited {
+		printProgress("覆蓋", i+1, len(files.Overwrited))
+		if err := files.overwriteFileAndMeta(name); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func overwriteFileAndMeta(name, bkRoot, mainRoot string) error {
-	if err := overwriteMetadata(name, bkRoot, mainRoot); err != nil {
+// overwriteFileAndMeta 先複製檔案，再複製 metadata, 然後記錄到 backup_progress.txt.
+// 如果目標專案保留舊版本，則先把舊的檔案與 metadata 移到快照資料夾。
+func (files ChangedFiles) overwriteFileAndMeta(name string) error {
+	mainFile := util.ReadFile(filepath.Join(files.MainRoot, util.METADATA, name+".json"))
+	if files.Done[name] == mainFile.Checksum {
+		return nil
+	}
+	if err := files.moveToSnapshot(util.FILES, name); err != nil {
 		return err
 	}
-	return overwriteFile(name, bkRoot, mainRoot)
+	if err := files.moveToSnapshot(util.METADATA, name+".json"); err != nil {
+		return err
+	}
+	if err := overwriteFile(name, files.BkRoot, files.MainRoot, mainFile.Checksum); err != nil {
+		return err
+	}
+	if err := overwriteMetadata(name, files.BkRoot, files.MainRoot); err != nil {
+		return err
+	}
+	return appendProgress(files.BkRoot, name, mainFile.Checksum)
 }
 
-func overwriteFile(name, bkRoot, mainRoot string) error {
+// overwriteFile 先複製到臨時檔案，驗證 checksum 後纔改名為正式檔案。
+func overwriteFile(name, bkRoot, mainRoot, checksum string) error {
 	src := filepath.Join(mainRoot, util.FILES, name)
 	dst := filepath.Join(bkRoot, util.FILES, name)
-	return util.CopyFile(dst, src)
+	tmp := filepath.Join(bkRoot, BackupTempPath)
+	return util.CopyFileVerified(dst, src, tmp, checksum)
+}
+
+// printProgress 在同一行中更新進度，例如 "新增 12/345".
+func printProgress(label string, i, n int) {
+	fmt.Printf("\r%s %d/%d", label, i, n)
+	if i == n {
+		fmt.Println()
+	}
+}
+
+func removeIfExists(name string) error {
+	if util.PathNotExists(name) {
+		return nil
+	}
+	return os.Remove(name)
+}
+
+// readProgress 讀取 backup_progress.txt, 每行的格式是 "checksum  filename".
+func readProgress(bkRoot string) (map[string]string, error) {
+	done := make(map[string]string)
+	progressPath := filepath.Join(bkRoot, BackupProgressPath)
+	if util.PathNotExists(progressPath) {
+		return done, nil
+	}
+	data, err := os.ReadFile(progressPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		sum, name, ok := strings.Cut(line, "  ")
+		if ok {
+			done[name] = sum
+		}
+	}
+	if len(done) > 0 {
+		fmt.Printf("繼續上次中斷的備份，跳過已完成的 %d 個檔案\n", len(done))
+	}
+	return done, nil
+}
+
+func appendProgress(bkRoot, name, checksum string) error {
+	progressPath := filepath.Join(bkRoot, BackupProgressPath)
+	f, err := os.OpenFile(progressPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, util.NormalFilePerm)
+	if err != nil {
+		return err
+	}
+	_, err1 := fmt.Fprintf(f, "%s  %s\n", checksum, name)
+	err2 := f.Close()
+	return util.WrapErrors(err1, err2)
 }
 
 func (files ChangedFiles) syncAdd() error {
-	for _, name := range files.Added {
-		fmt.Print(".")
-		if err := overwriteFileAndMeta(name, files.BkRoot, files.MainRoot); err != nil {
+	for i, name := range files.Added {
+		printProgress("新增", i+1, len(files.Added))
+		if err := files.overwriteFileAndMeta(name); err != nil {
 			return err
 		}
 	}
@@ -323,6 +768,9 @@ func getChangedFiles(mainRoot, bkRoot string, mainDB, bkDB *bolt.DB) (files Chan
 	files.MainRoot = mainRoot
 	files.BkRoot = bkRoot
 
+	deletedSums := make(map[string]string) // 已被刪除的檔案: filename => checksum
+	addedNames := make(map[string]string)  // 新增的檔案: id => filename
+
 	err = bkDB.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket(util.FilesBucket)
 		return b.ForEach(func(k, v []byte) error {
@@ -336,22 +784,7 @@ func getChangedFiles(mainRoot, bkRoot string, mainDB, bkDB *bolt.DB) (files Chan
 				return err
 			}
 
-			// 已被刪除的檔案
-			if mainFile == nil {
-				files.Deleted = append(files.Deleted, bkFile.Filename)
-				return nil
-			}
-
-			// 更新了內容的檔案
-			if bkFile.Checksum != mainFile.Checksum {
-				files.Overwrited = append(files.Overwrited, bkFile.Filename)
-				return nil
-			}
-
-			// 更新了屬性(metadata/json)的檔案
-			if bkFile.UTime != mainFile.UTime {
-				files.Updated = append(files.Updated, bkFile.Filename)
-			}
+			files.compareFile(&bkFile, mainFile, deletedSums)
 			return nil
 		})
 	})
@@ -372,14 +805,126 @@ func getChangedFiles(mainRoot, bkRoot string, mainDB, bkDB *bolt.DB) (files Chan
 				return err
 			}
 			if bkFile == nil {
-				files.Added = append(files.Added, mainFile.Filename)
+				addedNames[mainFile.ID] = mainFile.Filename
 			}
 			return nil
 		})
 	})
+	if err != nil {
+		return
+	}
+	err = files.findRenamed(deletedSums, addedNames, mainDB)
+	return
+}
+
+// compareFile 對比目標專案中的一個檔案與主專案中 ID 相同的檔案 (nil 表示已被刪除)。
+func (files *ChangedFiles) compareFile(bkFile, mainFile *File, deletedSums map[string]string) {
+	// 已被刪除的檔案
+	if mainFile == nil {
+		deletedSums[bkFile.Filename] = bkFile.Checksum
+		return
+	}
+
+	// 更新了內容的檔案
+	if !util.SameContent(bkFile, mainFile) {
+		files.Overwrited = append(files.Overwrited, bkFile.Filename)
+		return
+	}
+
+	// 更新了屬性(metadata/json)的檔案, 包括遷移了 checksum 算法 (不改變 UTime) 的檔案
+	if bkFile.UTime != mainFile.UTime ||
+		!slices.Equal(bkFile.AllChecksums(), mainFile.AllChecksums()) {
+		files.Updated = append(files.Updated, bkFile.Filename)
+	}
+}
+
+// compareProjects 找出需要同步的檔案。
+// 兩個數據庫都有 Merkle tree 時，只需對比有變化的檔案，根 hash 相同則無需對比；
+// 否則 (舊版本的數據庫) 逐一對比全部檔案, fullScan 為 true.
+func compareProjects(mainRoot, bkRoot string, mainDB, bkDB *bolt.DB) (files ChangedFiles, fullScan bool, err error) {
+	ids, ok, err := util.MerkleDiff(mainDB, bkDB)
+	if err != nil {
+		return
+	}
+	if !ok {
+		fmt.Println("數據庫中沒有 Merkle tree, 逐一對比全部檔案。")
+		files, err = getChangedFiles(mainRoot, bkRoot, mainDB, bkDB)
+		return files, true, err
+	}
+	if len(ids) == 0 {
+		fmt.Printf("Merkle root 相同: 目標專案與源專案一致\n\n")
+	} else {
+		fmt.Printf("Merkle root 不同: 有變化的檔案 %d\n\n", len(ids))
+	}
+	files, err = getChangedFilesByIDs(mainRoot, bkRoot, ids, mainDB, bkDB)
+	return
+}
+
+// getChangedFilesByIDs 與 getChangedFiles 相同，但只對比 ids 中的檔案。
+func getChangedFilesByIDs(mainRoot, bkRoot string, ids []string, mainDB, bkDB *bolt.DB) (files ChangedFiles, err error) {
+	files.MainRoot = mainRoot
+	files.BkRoot = bkRoot
+
+	deletedSums := make(map[string]string) // 已被刪除的檔案: filename => checksum
+	addedNames := make(map[string]string)  // 新增的檔案: id => filename
+
+	for _, id := range ids {
+		mainFile, err := getFileByID(id, mainDB)
+		if err != nil {
+			return files, err
+		}
+		bkFile, err := getFileByID(id, bkDB)
+		if err != nil {
+			return files, err
+		}
+		if bkFile == nil {
+			if mainFile != nil {
+				addedNames[id] = mainFile.Filename
+			}
+			continue
+		}
+		files.compareFile(bkFile, mainFile, deletedSums)
+	}
+	err = files.findRenamed(deletedSums, addedNames, mainDB)
 	return
 }
 
+// findRenamed 通過主專案的 ChecksumBucket 找出改了名的檔案 (checksum 相同, ID 不同),
+// 其餘的纔是真正被刪除或新增的檔案。
+func (files *ChangedFiles) findRenamed(deletedSums, addedNames map[string]string, mainDB *bolt.DB) error {
+	err := mainDB.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(util.ChecksumBucket)
+		for oldName, sum := range deletedSums {
+			data := b.Get([]byte(util.NormalizeChecksum(sum)))
+			if data == nil {
+				continue
+			}
+			var ids []string
+			if err := json.Unmarshal(data, &ids); err != nil {
+				return err
+			}
+			for _, id := range ids {
+				newName, ok := addedNames[id]
+				if !ok {
+					continue
+				}
+				files.Renamed = append(files.Renamed, RenamedFile{oldName, newName})
+				delete(deletedSums, oldName)
+				delete(addedNames, id)
+				break
+			}
+		}
+		return nil
+	})
+	for name := range deletedSums {
+		files.Deleted = append(files.Deleted, name)
+	}
+	for _, name := range addedNames {
+		files.Added = append(files.Added, name)
+	}
+	return err
+}
+
 // 如果 err == nil && f == nil, 则意味着 id 不存在。
 func getFileByID(id string, db *bolt.DB) (f *File, err error) {
 	err = db.View(func(tx *bolt.Tx) error {
@@ -403,35 +948,43 @@ func unmarshalFile(data []byte) (f File, err error) {
 	return
 }
 
+// autoFix 修復主專案與目標專案中的受損檔案，從全部已登記的專案中尋找有用檔案。
 func autoFix(mainRoot, bkRoot string, mainDB, bkDB *bolt.DB) error {
-	if err := autoFixOneWay(mainRoot, bkRoot, mainDB); err != nil {
+	if err := autoFixOneWay(mainRoot, mainDB, true); err != nil {
 		return err
 	}
-	return autoFixOneWay(bkRoot, mainRoot, bkDB)
+	return autoFixOneWay(bkRoot, bkDB, false)
 }
 
-// 從 root1 和 db 中找出受損檔案, 再從 root2 中尋找有用檔案。
-// 有用檔案是指與受損檔案對應的完整檔案。
-func autoFixOneWay(root1, root2 string, db *bolt.DB) error {
-	fcMap, err := util.ReadFileChecked(root1)
+// 從 root 和 db 中找出受損檔案, 再從全部專案中尋找有用檔案。
+// 有用檔案是指與受損檔案對應的完整檔案 (checksum 與 metadata 中記錄的一致)。
+// 如果 isMain 為 true, 則允許通過投票修正 metadata 中的 checksum.
+func autoFixOneWay(root string, db *bolt.DB, isMain bool) error {
+	fcMap, err := util.ReadFileChecked(root)
 	if err != nil {
 		return err
 	}
-	ids := util.DamagedOfFileChecked(fcMap)
-	if len(ids) == 0 {
-		fmt.Println("無受損檔案 =>", root1)
-		return nil
-	}
-	damagedFiles, err := getFilesByIDs(ids, db)
+	// 先修復 metadata, 因為檢查檔案時使用 metadata 中的 checksum.
+	changed, err := fixMetadata(root, fcMap, db, findMetaInProjects)
 	if err != nil {
 		return err
 	}
-	changed, err := fixFiles(root1, root2, damagedFiles, fcMap)
-	if err != nil {
-		return err
+	ids := util.DamagedOfFileChecked(fcMap)
+	if len(ids) == 0 {
+		fmt.Println("無受損檔案 =>", root)
+	} else {
+		damagedFiles, err := getFilesByIDs(ids, db)
+		if err != nil {
+			return err
+		}
+		fixed, err := fixFiles(root, damagedFiles, fcMap, db, isMain)
+		if err != nil {
+			return err
+		}
+		changed = changed || fixed
 	}
 	if changed {
-		fileCheckedPath := filepath.Join(root1, util.FileCheckedPath)
+		fileCheckedPath := filepath.Join(root, util.FileCheckedPath)
 		fmt.Println("Update =>", fileCheckedPath)
 		_, err := util.WriteJSON(fcMap, fileCheckedPath)
 		return err
@@ -439,33 +992,150 @@ func autoFixOneWay(root1, root2 string, db *bolt.DB) error {
 	return nil
 }
 
-// files 是 root1 里的受损档案, fcMap 是 root1 的档案检查列表。
+// FileCopy 是某個專案中的一個檔案副本。
+type FileCopy struct {
+	Root string
+	Sum  string
+}
+
+func (c FileCopy) Path(name string) string {
+	return filepath.Join(c.Root, util.FILES, name)
+}
+
+// findCopies 在 root 及全部已登記的專案中尋找同名檔案，並使用算法 algo 計算 checksum.
+// 第一個是 root 裏的副本 (如果存在)。無法訪問的專案與加密目標專案會被跳過。
+func findCopies(root, name, algo string) (copies []FileCopy, err error) {
+	roots, err := projectRoots(root)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range roots {
+		c := FileCopy{Root: r}
+		if util.PathNotExists(c.Path(name)) {
+			continue
+		}
+		if c.Sum, err = util.FileSum(c.Path(name), algo); err != nil {
+			return nil, err
+		}
+		copies = append(copies, c)
+	}
+	return
+}
+
+// projectRoots 返回 root 及全部已登記的專案，第一個是 root.
+func projectRoots(root string) (roots []string, err error) {
+	roots = []string{root}
+	rootAbs, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	for _, project := range MainProjInfo.Projects {
+		abs, err := filepath.Abs(project)
+		if err != nil {
+			return nil, err
+		}
+		if abs != rootAbs {
+			roots = append(roots, project)
+		}
+	}
+	return
+}
+
+// majority 返回多數副本的 checksum 及其數量。
+func majority(copies []FileCopy) (sum string, n int) {
+	votes := make(map[string]int)
+	for _, c := range copies {
+		votes[c.Sum]++
+		if votes[c.Sum] > n {
+			sum, n = c.Sum, votes[c.Sum]
+		}
+	}
+	return
+}
+
+// files 是 root 里的受损档案, fcMap 是 root 的档案检查列表。
 // 如果 changed==true, 說明 fcMap 的内容已改變。
-func fixFiles(root1, root2 string, files []*File, fcMap map[string]*FileChecked) (changed bool, err error) {
-	var fixedIDs []string
+//
+// 修復方法：
+//  1. 在全部專案中尋找 checksum 與 metadata 一致的副本，複製到 root.
+//  2. 如果找不到，而且 isMain 為 true, 副本 (包括 root 裏的副本) 有三個或以上，
+//     並且超過半數的副本內容相同，則認為 metadata 中的 checksum 有誤，
+//     採用多數副本的內容，並修正 metadata 中的 checksum.
+func fixFiles(root string, files []*File, fcMap map[string]*FileChecked, db *bolt.DB, isMain bool) (changed bool, err error) {
+	tmp := filepath.Join(root, BackupTempPath)
 	for _, f := range files {
-		filepath1 := filepath.Join(root1, util.FILES, f.Filename)
-		filepath2 := filepath.Join(root2, util.FILES, f.Filename)
-		sum, err := util.FileSum512(filepath2)
+		dst := filepath.Join(root, util.FILES, f.Filename)
+		algo, _ := util.SplitChecksum(f.Checksum)
+		copies, err := findCopies(root, f.Filename, algo)
 		if err != nil {
 			return false, err
 		}
-		if sum != f.Checksum {
-			fmt.Println("未修復 =>", filepath1)
+		good, ok := lo.Find(copies, func(c FileCopy) bool { return util.ChecksumEqual(c.Sum, f.Checksum) })
+		if ok {
+			if good.Root == root {
+				fmt.Println("檔案完好 =>", dst)
+			} else {
+				fmt.Println("發現有用檔案 =>", good.Path(f.Filename))
+				fmt.Println("自動修復 =>", dst)
+				if err = util.CopyFileVerified(dst, good.Path(f.Filename), tmp, f.Checksum); err != nil {
+					return false, err
+				}
+			}
+			fcMap[f.ID].Damaged = false
+			changed = true
+			continue
+		}
+
+		sum, n := majority(copies)
+		if !isMain || len(copies) < 3 || n*2 <= len(copies) {
+			fmt.Printf("未修復 => %s (找到 %d 個副本，均與 checksum 不一致)\n", dst, len(copies))
 			continue
 		}
-		fmt.Println("發現有用檔案 =>", filepath2)
-		fmt.Println("自動修復 =>", filepath1)
-		if err = util.CopyFile(filepath1, filepath2); err != nil {
+		good, _ = lo.Find(copies, func(c FileCopy) bool { return c.Sum == sum })
+		fmt.Printf("metadata 中的 checksum 可能有誤，採用多數副本 (%d/%d) => %s\n",
+			n, len(copies), good.Path(f.Filename))
+		if good.Root != root {
+			fmt.Println("自動修復 =>", dst)
+			if err = util.CopyFileVerified(dst, good.Path(f.Filename), tmp, sum); err != nil {
+				return false, err
+			}
+		}
+		if err = fixChecksum(root, f, sum, db); err != nil {
 			return false, err
 		}
-		fixedIDs = append(fixedIDs, f.ID)
 		fcMap[f.ID].Damaged = false
 		changed = true
 	}
 	return
 }
 
+// fixChecksum 修正 metadata 與數據庫中的 checksum, 並更新 UTime,
+// 使下次備份時同步到目標專案。
+func fixChecksum(root string, f *File, sum string, db *bolt.DB) error {
+	info, err := os.Stat(filepath.Join(root, util.FILES, f.Filename))
+	if err != nil {
+		return err
+	}
+	newFile := *f
+	newFile.Checksum = sum
+	newFile.Checksums = nil // 其他算法的 checksum 已失效
+	newFile.Size = info.Size()
+	newFile.MTime = util.MTimeOf(info)
+	newFile.UTime = util.Now()
+	metaPath := filepath.Join(root, util.METADATA, f.Filename+".json")
+	fmt.Println("Update =>", metaPath)
+	data, err := util.WriteJSON(newFile, metaPath)
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(util.FilesBucket).Put([]byte(f.ID), data); err != nil {
+			return err
+		}
+		return util.UpdateIndexes(f, &newFile, tx)
+	})
+}
+
 func getFilesByIDs(ids []string, db *bolt.DB) (files []*File, err error) {
 	err = db.View(func(tx *bolt.Tx) error {
 		files, err = util.GetFilesByIDs(ids, tx)
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ahui2016/wuliu/util"
+	"github.com/samber/lo"
+	"log"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// promote 把當前的備份專案變成主專案 (用於主專案的硬碟損壞等情況)。
+// 有受損檔案或孤立檔案時拒絕執行。
+func promote(danger bool) {
+	if !MainProjInfo.IsBackup {
+		log.Fatalln("這不是備份專案 (IsBackup 是 false)")
+	}
+	util.PrintErrorExit(checkBeforePromote())
+
+	newInfo, err := promotedProjInfo(MainProjInfo)
+	util.PrintErrorExit(err)
+	fmt.Println("新的 Projects:")
+	for i, project := range newInfo.Projects {
+		fmt.Printf("%d %s\n", i, project)
+	}
+	fmt.Println()
+
+	if !danger {
+		fmt.Println("(尚未實際執行，使用參數 '-danger' 纔會實際執行)")
+		return
+	}
+
+	MainProjInfo = newInfo
+	fmt.Println("Update =>", util.ProjectInfoPath)
+	lo.Must0(util.WriteProjectInfo(MainProjInfo))
+	util.MakeFolders(".", false)
+	util.RebuildDatabase(".")
+	db := lo.Must(util.OpenDB("."))
+	lo.Must0(util.ReconcileFileChecked(db))
+	db.Close()
+	for _, bkRoot := range MainProjInfo.Projects[1:] {
+		updateBkProjInfo(bkRoot)
+	}
+	fmt.Println("\n已成為主專案。")
+}
+
+// checkBeforePromote 檢查孤立檔案與受損檔案，並列印檔案檢查狀態。
+func checkBeforePromote() error {
+	fileOrphans, metaOrphans, err := util.FindOrphans()
+	if err != nil {
+		return err
+	}
+	if len(fileOrphans)+len(metaOrphans) > 0 {
+		return fmt.Errorf("發現孤立檔案，請執行 wuliu-orphan")
+	}
+
+	fcMap, err := util.ReadFileChecked(".")
+	if err != nil {
+		return err
+	}
+	if damaged := util.DamagedOfFileChecked(fcMap); len(damaged) > 0 {
+		util.PrintList(damaged)
+		return fmt.Errorf("發現 %d 個受損檔案，必須修復後纔能成為主專案", len(damaged))
+	}
+	if damaged := util.MetaDamagedOfFileChecked(fcMap); len(damaged) > 0 {
+		util.PrintList(damaged)
+		return fmt.Errorf("發現 %d 個受損 metadata, 必須修復後纔能成為主專案", len(damaged))
+	}
+
+	interval := time.Duration(MainProjInfo.CheckInterval) * util.Day * time.Second
+	deadline := time.Now().Add(-interval).Format(util.RFC3339)
+	files, err := util.GetAllFilesTxMetadata(".")
+	if err != nil {
+		return err
+	}
+	var neverChecked, overdue int
+	for _, f := range files {
+		fc, ok := fcMap[f.ID]
+		if !ok || fc.Checked == util.Epoch {
+			neverChecked++
+		} else if fc.Checked < deadline {
+			overdue++
+		}
+	}
+	fmt.Printf("檔案數量\t%d\n", len(files))
+	fmt.Printf("從未檢查\t%d\n", neverChecked)
+	fmt.Printf("超過檢查週期\t%d\n", overdue)
+	if neverChecked+overdue > 0 {
+		fmt.Println("建議先執行 wuliu-checksum -check 檢查檔案完整性。")
+	}
+	fmt.Println()
+	return nil
+}
+
+// promotedProjInfo 以當前專案為主專案，重寫 Projects 與 LastBackupAt.
+// 原主專案 (在備份專案中記錄為 ".") 與當前專案本身會被移除，其餘備份專案保持不變。
+func promotedProjInfo(info ProjectInfo) (ProjectInfo, error) {
+	cwd, err := filepath.Abs(".")
+	if err != nil {
+		return info, err
+	}
+	self := -1
+	for i, project := range info.Projects {
+		if i == 0 {
+			continue
+		}
+		abs, err := filepath.Abs(project)
+		if err != nil {
+			return info, err
+		}
+		if abs == cwd {
+			self = i
+		}
+	}
+	if self < 0 {
+		// 舊版本以相對路徑登記備份專案，在主專案中執行一次備份後會更新為絕對路徑。
+		return info, fmt.Errorf("在 Projects 中找不到當前專案: %s\n"+
+			"如果 Projects 中是相對路徑，請先在主專案中執行一次備份", cwd)
+	}
+
+	newInfo := info
+	newInfo.IsBackup = false
+	newInfo.Projects = []string{"."}
+	newInfo.LastBackupAt = []string{info.LastBackupAt[self]}
+	for i := 1; i < len(info.Projects); i++ {
+		if i == self {
+			continue
+		}
+		newInfo.Projects = append(newInfo.Projects, info.Projects[i])
+		newInfo.LastBackupAt = append(newInfo.LastBackupAt, info.LastBackupAt[i])
+	}
+	newInfo.VersionedProjects = slices.DeleteFunc(slices.Clone(info.VersionedProjects),
+		func(p string) bool { return p == info.Projects[self] })
+	return newInfo, nil
+}
+
+// updateBkProjInfo 更新備份專案的 project.json, 使其指向新的主專案。
+// 無法訪問的備份專案會被跳過，下次備份時會自動更新。
+func updateBkProjInfo(bkRoot string) {
+	if err := checkReachable(bkRoot); err != nil {
+		fmt.Println("跳過:", err)
+		return
+	}
+	if isEncrypted(bkRoot) {
+		return
+	}
+	bkInfo := util.ReadProjectInfo(bkRoot)
+	if bkInfo.ProjectName != MainProjInfo.ProjectName || !bkInfo.IsBackup {
+		fmt.Println("跳過 (專案名稱不一致或不是備份專案):", bkRoot)
+		return
+	}
+	bkInfo = MainProjInfo
+	bkInfo.IsBackup = true
+	bkProjInfoPath := filepath.Join(bkRoot, util.ProjectInfoPath)
+	fmt.Println("Update =>", bkProjInfoPath)
+	_ = lo.Must(util.WriteJSON(bkInfo, bkProjInfoPath))
+}
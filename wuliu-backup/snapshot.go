@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ahui2016/wuliu/util"
+	"github.com/samber/lo"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// 保留舊版本的目標專案，每次備份時都會在 snapshots 資料夾中新建一個快照資料夾
+// (以備份時間命名), 被覆蓋或刪除的舊檔案及其 metadata 會移到快照資料夾中的
+// files 和 metadata 資料夾，而不是直接覆蓋或刪除。
+
+// setVersioned 設定目標專案是否保留舊版本, value 只能是 "on" 或 "off".
+func setVersioned(bkRoot, value string) {
+	if isEncrypted(bkRoot) {
+		log.Fatalln("加密目標專案不支持保留舊版本:", bkRoot)
+	}
+	switch value {
+	case "on":
+		if !slices.Contains(MainProjInfo.VersionedProjects, bkRoot) {
+			MainProjInfo.VersionedProjects = append(MainProjInfo.VersionedProjects, bkRoot)
+		}
+	case "off":
+		MainProjInfo.VersionedProjects = slices.DeleteFunc(
+			MainProjInfo.VersionedProjects, func(p string) bool { return p == bkRoot })
+	default:
+		log.Fatalln("參數 '-versioned' 只能是 on 或 off")
+	}
+	fmt.Printf("保留舊版本 (%s) => %s\n", value, bkRoot)
+	fmt.Println("Update =>", util.ProjectInfoPath)
+	lo.Must0(util.WriteProjectInfo(MainProjInfo))
+}
+
+// newSnapshotFolder 返回一個新的快照資料夾路徑 (不會自動創建)。
+func newSnapshotFolder(bkRoot string) string {
+	name := time.Now().Format(util.RecycleTimeFormat)
+	return filepath.Join(bkRoot, util.SNAPSHOTS, name)
+}
+
+// moveToSnapshot 把目標專案中的舊檔案移到快照資料夾, folder 是 files 或 metadata.
+// 如果目標專案不保留舊版本，或舊檔案不存在，則不做任何事。
+func (files ChangedFiles) moveToSnapshot(folder, name string) error {
+	src := filepath.Join(files.BkRoot, folder, name)
+	if files.Snapshot == "" || util.PathNotExists(src) {
+		return nil
+	}
+	dstDir := filepath.Join(files.Snapshot, folder)
+	if err := os.MkdirAll(dstDir, util.NormalDirPerm); err != nil {
+		return err
+	}
+	return os.Rename(src, filepath.Join(dstDir, name))
+}
+
+// removeOrSnapshot 刪除目標專案中的檔案，如果目標專案保留舊版本，則移到快照資料夾。
+func (files ChangedFiles) removeOrSnapshot(folder, name string) error {
+	if files.Snapshot != "" {
+		return files.moveToSnapshot(folder, name)
+	}
+	return removeIfExists(filepath.Join(files.BkRoot, folder, name))
+}
+
+// readSnapshots 返回全部快照的名稱，從新到舊排序。
+func readSnapshots(bkRoot string) (names []string, err error) {
+	snapshotsDir := filepath.Join(bkRoot, util.SNAPSHOTS)
+	if util.PathNotExists(snapshotsDir) {
+		return nil, nil
+	}
+	items, err := os.ReadDir(snapshotsDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range items {
+		if item.IsDir() {
+			names = append(names, item.Name())
+		}
+	}
+	slices.Sort(names)
+	slices.Reverse(names)
+	return
+}
+
+// readSnapshotFiles 返回一個快照中的全部檔案名稱，只有 metadata 的檔案
+// (即只更新了屬性的檔案) 的名稱以 ".json" 結尾。
+func readSnapshotFiles(snapshotDir string) (names []string, err error) {
+	filesDir := filepath.Join(snapshotDir, util.FILES)
+	metaDir := filepath.Join(snapshotDir, util.METADATA)
+	if util.PathExists(filesDir) {
+		if names, err = util.GetFilenamesBase(filesDir); err != nil {
+			return
+		}
+	}
+	if util.PathNotExists(metaDir) {
+		return
+	}
+	metaNames, err := util.GetFilenamesBase(metaDir)
+	if err != nil {
+		return
+	}
+	for _, meta := range metaNames {
+		if !slices.Contains(names, strings.TrimSuffix(meta, ".json")) {
+			names = append(names, meta)
+		}
+	}
+	return
+}
+
+func printSnapshots(bkRoot string) error {
+	snapshotNames, err := readSnapshots(bkRoot)
+	if err != nil {
+		return err
+	}
+	isVersioned := slices.Contains(MainProjInfo.VersionedProjects, bkRoot)
+	fmt.Printf("保留舊版本: %s\n", lo.Ternary(isVersioned, "on", "off"))
+	if len(snapshotNames) == 0 {
+		fmt.Println("無快照 =>", bkRoot)
+		return nil
+	}
+	for _, snapshot := range snapshotNames {
+		names, err := readSnapshotFiles(filepath.Join(bkRoot, util.SNAPSHOTS, snapshot))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s (%d)\n", snapshot, len(names))
+		for _, name := range names {
+			fmt.Printf("  %s/%s\n", snapshot, name)
+		}
+	}
+	return nil
+}
+
+// restoreFromSnapshot 把快照中的檔案及其 metadata 複製到主專案的 buffer 資料夾，
+// target 的格式是 "SNAPSHOT/FILENAME".
+func restoreFromSnapshot(bkRoot, target string) error {
+	snapshot, name, ok := strings.Cut(filepath.ToSlash(target), "/")
+	if !ok || snapshot == "" || name == "" {
+		return fmt.Errorf("格式錯誤，應為 SNAPSHOT/FILENAME: %s", target)
+	}
+	// 防止通過 ".." 或絕對路徑讀寫 snapshots 與 buffer 資料夾以外的檔案。
+	if !filepath.IsLocal(snapshot) || !filepath.IsLocal(filepath.FromSlash(name)) {
+		return fmt.Errorf("SNAPSHOT/FILENAME 不可包含 '..' 或絕對路徑: %s", target)
+	}
+	snapshotDir := filepath.Join(bkRoot, util.SNAPSHOTS, snapshot)
+	if util.PathNotExists(filepath.Join(snapshotDir, util.FILES, name)) {
+		name = strings.TrimSuffix(name, ".json") // 只有 metadata 的檔案
+	}
+	srcFile := filepath.Join(snapshotDir, util.FILES, name)
+	srcMeta := filepath.Join(snapshotDir, util.METADATA, name+".json")
+	dstFile := filepath.Join(util.BUFFER, name)
+	dstMeta := filepath.Join(util.BUFFER, name+".json")
+
+	var pairs [][2]string
+	for _, pair := range [][2]string{{srcFile, dstFile}, {srcMeta, dstMeta}} {
+		if util.PathExists(pair[0]) {
+			pairs = append(pairs, pair)
+		}
+	}
+	if len(pairs) == 0 {
+		return fmt.Errorf("Not Found: %s", target)
+	}
+	for _, pair := range pairs {
+		if util.PathExists(pair[1]) {
+			return fmt.Errorf("file exists: %s", pair[1])
+		}
+	}
+	for _, pair := range pairs {
+		fmt.Printf("Restore: %s => %s\n", pair[0], pair[1])
+		if err := util.CopyFile(pair[1], pair[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
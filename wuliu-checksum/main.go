@@ -8,7 +8,6 @@ import (
 	bolt "go.etcd.io/bbolt"
 	"log"
 	"path/filepath"
-	"time"
 )
 
 type (
@@ -16,10 +15,6 @@ type (
 	FileChecked = util.FileChecked
 )
 
-const (
-	MB = util.MB
-)
-
 var (
 	MainProject = util.ReadProjectInfo(".")
 )
@@ -29,13 +24,25 @@ var (
 	projectsFlag = flag.Bool("projects", false, "list all projects")
 	nFlag        = flag.Int("n", 0, "select a project by a number (default: 0)")
 	checkFlag    = flag.Bool("check", false, "check if files are corrupted")
+	metaFlag     = flag.Bool("meta", false, "check if metadata (json files) are corrupted")
+	quickFlag    = flag.Bool("quick", false, "quickly check size and modification time of all files")
+	workersFlag  = flag.Int("workers", 0, "number of files to check at the same time (0: auto)")
+	mbpsFlag     = flag.Int("mbps", 0, "limit the read speed in MB/s (0: no limit)")
+	likeDaysFlag = flag.Int("like-days", 0, "each like makes a file due for check N days earlier")
+	timeFlag     = flag.Duration("time", 0, "stop checking after this duration, e.g. 30m (0: no limit)")
+	sameFlag     = flag.Bool("same", false, "find files with the same content")
+	jsonFlag     = flag.String("json", "", "use with '-same', write IDs of duplicates to a JSON file for wuliu-delete")
+	mergeFlag    = flag.Bool("merge", false, "use with '-same', merge attributes of duplicates into the file to keep")
+	migrateFlag  = flag.String("migrate", "", "recompute checksums of all files with a new hash algorithm, e.g. sha256")
+	parityFlag   = flag.Bool("parity", false, "create parity files for repairing small corruptions (see ParityPercent in project.json)")
+	danger       = flag.Bool("danger", false, "use with '-merge' or '-migrate', really do it")
 )
 
 func main() {
 	flag.Parse()
 	util.MustInWuliu()
 
-	if !(*renewFlag || *projectsFlag || *checkFlag) {
+	if !(*renewFlag || *projectsFlag || *checkFlag || *metaFlag || *quickFlag || *sameFlag || *migrateFlag != "" || *parityFlag) {
 		flag.Usage()
 	}
 
@@ -46,6 +53,28 @@ func main() {
 		return
 	}
 
+	if *sameFlag {
+		if *nFlag != 0 {
+			log.Fatalln("參數 '-same' 不可與 '-n' 同時使用")
+		}
+		db := lo.Must(util.OpenDB("."))
+		defer db.Close()
+		findSame(db)
+		return
+	}
+
+	if *migrateFlag != "" {
+		if *nFlag != 0 {
+			log.Fatalln("參數 '-migrate' 不可與 '-n' 同時使用")
+		}
+		util.CheckNotAllowInBackup()
+		util.PrintErrorExit(util.CheckHashAlgorithm(*migrateFlag))
+		db := lo.Must(util.OpenDB("."))
+		defer db.Close()
+		migrate(*migrateFlag, db)
+		return
+	}
+
 	db := lo.Must(util.OpenDB(root))
 	defer db.Close()
 
@@ -63,20 +92,42 @@ func main() {
 		doCheck(root, fcMap, db)
 		return
 	}
+
+	if *quickFlag {
+		fmt.Println("已選擇專案:", root)
+		quickCheck(root, fcMap, db)
+		return
+	}
+
+	if *metaFlag {
+		fmt.Println("已選擇專案:", root)
+		checkMeta(root, fcMap, db)
+		return
+	}
+
+	if *parityFlag {
+		fmt.Println("已選擇專案:", root)
+		updateParity(root, db)
+		return
+	}
 }
 
 func doCheck(root string, fcMap map[string]*FileChecked, db *bolt.DB) {
-	checkN, checkedSize := checkChecksum(root, fcMap, db)
+	checkN, checkedSize, checkErr := checkChecksum(root, fcMap, db)
 	totalSize := util.FileSizeToString(float64(checkedSize), 2)
 	fmt.Println("本次檢查檔案數量:", checkN)
 	fmt.Println("本次檢查檔案體積:", totalSize)
+	repairN := repairDamaged(root, fcMap, db)
 	printDamaged(fcMap, db)
-	if checkN > 0 {
+	printMetaDamaged(fcMap, db)
+	// 個別檔案出錯 (例如找不到檔案) 時，其他檔案的檢查結果也要保存。
+	if checkN+repairN > 0 || checkErr != nil {
 		fileCheckedPath := filepath.Join(root, util.FileCheckedPath)
 		fmt.Println("Update =>", fileCheckedPath)
 		_ = lo.Must(
 			util.WriteJSON(fcMap, fileCheckedPath))
 	}
+	util.PrintErrorExit(checkErr)
 }
 
 func printProjectsList() {
@@ -99,6 +150,9 @@ func printDamaged(fcMap map[string]*FileChecked, db *bolt.DB) {
 	for i := range ids {
 		fmt.Println(ids[i], names[i])
 	}
+	if len(ids) > 0 {
+		fmt.Println("可使用 `wuliu-backup -fix` 從備份專案修復。")
+	}
 }
 
 func bucketKeysCount(db *bolt.DB) (n int) {
@@ -124,47 +178,13 @@ func allIDs(db *bolt.DB) (ids []string) {
 }
 
 // 注意，該函數運行後, fcMap 的内容也会改变。
-func checkChecksum(root string, fcMap map[string]*FileChecked, db *bolt.DB) (checkN int, checkedSize int64) {
-	now := util.Now()
-	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(util.FilesBucket)
-		for id := range fcMap {
-			needCheck := isFileNeedCheck(fcMap[id].Checked, MainProject.CheckInterval)
-			if needCheck {
-				f := lo.Must(util.GetFileInBucket(id, b))
-				fmt.Print(".")
-				fcMap[id].Damaged = checkFile(root, f)
-				fcMap[id].Checked = now
-				checkN += 1
-				checkedSize += f.Size
-			}
-			// checkN > 0 是为了确保至少检查一个档案
-			if checkN > 0 && checkedSize > int64(MainProject.CheckSizeLimit*MB) {
-				return nil
-			}
-		}
-		fmt.Println()
-		return nil
-	})
-	lo.Must0(err)
-	return
-}
-
-func checkFile(root string, file File) (damaged bool) {
-	fPath := filepath.Join(root, util.FILES, file.Filename)
-	sum := lo.Must(util.FileSum512(fPath))
-	if sum != file.Checksum {
-		damaged = true
-	}
-	return
-}
-
-func isFileNeedCheck(checked string, intervalDay int) bool {
-	interval := intervalDay * util.Day
-	needCheckUnix := time.Now().Unix() - int64(interval)
-	needCheckDate := time.Unix(needCheckUnix, 0).Format(util.RFC3339)
-	// 如果上次校验日期小于(早于) needCheckDate, 就需要再次校验。
-	return checked < needCheckDate
+func checkChecksum(root string, fcMap map[string]*FileChecked, db *bolt.DB) (checkN int, checkedSize int64, err error) {
+	opts := util.NewCheckOptions(MainProject)
+	opts.Workers = *workersFlag
+	opts.MBps = *mbpsFlag
+	opts.LikeDays = *likeDaysFlag
+	opts.TimeLimit = *timeFlag
+	return util.CheckFiles(root, fcMap, opts, db)
 }
 
 func renewFileChecked(root string, db *bolt.DB) int {
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/ahui2016/wuliu/util"
+	"github.com/samber/lo"
+	bolt "go.etcd.io/bbolt"
+)
+
+// checkMeta 檢查專案 root 中的全部 metadata, 把有問題的檔案標記為 MetaDamaged
+// (沒有問題的檔案則取消標記), 並寫入 file_checked.json.
+// metadata 受損與檔案受損分開記錄，互不影響。
+func checkMeta(root string, fcMap map[string]*FileChecked, db *bolt.DB) {
+	fcMap = lo.Must(util.ReconcileFileCheckedMap(fcMap, db))
+	problems, checkN, err := util.CheckMetadata(root, db)
+	util.PrintErrorExit(err)
+	fmt.Println("本次檢查 metadata 數量:", checkN)
+
+	for _, fc := range fcMap {
+		fc.MetaDamaged = false
+	}
+	fmt.Println("有問題的 metadata:", len(problems))
+	for _, p := range problems {
+		fmt.Println(p.ID, p.Filename)
+		for _, msg := range p.Problems {
+			fmt.Println("  ", msg)
+		}
+		// 數據庫中沒有的檔案不能標記，只列印出來。
+		if fc, ok := fcMap[p.ID]; ok {
+			fc.MetaDamaged = true
+		}
+	}
+	if len(problems) > 0 {
+		fmt.Println("可使用 `wuliu-backup -fix` 從備份專案的 metadata 修復。")
+	}
+
+	fileCheckedPath := filepath.Join(root, util.FileCheckedPath)
+	fmt.Println("Update =>", fileCheckedPath)
+	_ = lo.Must(
+		util.WriteJSON(fcMap, fileCheckedPath))
+}
+
+func printMetaDamaged(fcMap map[string]*FileChecked, db *bolt.DB) {
+	ids := util.MetaDamagedOfFileChecked(fcMap)
+	if len(ids) == 0 {
+		return
+	}
+	names, err := util.IdsToNames(ids, db)
+	util.PrintErrorExit(err)
+	fmt.Println("metadata 已損壞的檔案:", len(ids))
+	for i := range ids {
+		fmt.Println(ids[i], names[i])
+	}
+}
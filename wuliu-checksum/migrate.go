@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/ahui2016/wuliu/util"
+	"github.com/samber/lo"
+	bolt "go.etcd.io/bbolt"
+)
+
+// migrate 把全部檔案的主 checksum 改為使用算法 algo, 舊的 checksum 保留在 Checksums 中，
+// 並把 project.json 的 HashAlgorithm 設為 algo (以後添加的檔案使用新算法)。
+// 計算新 checksum 的同時驗證舊 checksum, 不一致的檔案視為受損，不遷移。
+// 每個檔案遷移後立即更新 metadata 與數據庫, file_checked.json 則定時寫入，
+// 按 Ctrl-C 中斷時也會寫入。中斷後重新執行會跳過已遷移的檔案。
+func migrate(algo string, db *bolt.DB) {
+	files := lo.Must(util.GetAllFiles(db))
+	todo := lo.Filter(files, func(f *File, _ int) bool {
+		oldAlgo, _ := util.SplitChecksum(f.Checksum)
+		return oldAlgo != algo
+	})
+	totalSize := lo.SumBy(todo, func(f *File) int64 { return f.Size })
+	fmt.Println("目標算法:", algo)
+	fmt.Println("需要遷移的檔案數量:", len(todo))
+	fmt.Println("需要遷移的檔案體積:", util.FileSizeToString(float64(totalSize), 2))
+	if !*danger {
+		fmt.Println("(尚未實際執行，使用參數 '-danger' 纔會實際執行)")
+		return
+	}
+
+	if MainProject.HashAlgorithm != algo {
+		MainProject.HashAlgorithm = algo
+		fmt.Println("Update =>", util.ProjectInfoPath)
+		lo.Must0(util.WriteProjectInfo(MainProject))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	fcMap := lo.Must(util.ReadFileChecked("."))
+	lastFlush := time.Now()
+	var damaged []string
+	for i, f := range todo {
+		if time.Since(lastFlush) > migrateFlushInterval {
+			lo.Must(util.WriteJSON(fcMap, util.FileCheckedPath))
+			lastFlush = time.Now()
+		}
+		fmt.Printf("[%d/%d] ", i+1, len(todo))
+		oldSum, newSum, err := sumTwice(ctx, f, algo)
+		if ctx.Err() != nil {
+			fmt.Println("已中斷遷移")
+			break
+		}
+		util.PrintErrorExit(err)
+		fc, ok := fcMap[f.ID]
+		if !util.ChecksumEqual(oldSum, f.Checksum) {
+			fmt.Println("checksum 不一致，不遷移:", f.Filename)
+			damaged = append(damaged, f.ID)
+			if ok {
+				fc.Damaged = true
+			}
+			continue
+		}
+		newFile := *f
+		newFile.Checksum = newSum
+		newFile.Checksums = lo.Without(f.AllChecksums(), newSum)
+		// 不改變 UTime: 檔案內容與屬性都沒有變化。
+		fmt.Println("Update =>", filepath.Join(util.METADATA, f.Filename+".json"))
+		lo.Must0(util.RewriteFile(".", f, &newFile, db))
+		if ok {
+			fc.Checked = util.Now()
+			fc.Damaged = false
+		}
+	}
+	fmt.Println("Update =>", util.FileCheckedPath)
+	lo.Must(util.WriteJSON(fcMap, util.FileCheckedPath))
+	if len(damaged) > 0 {
+		fmt.Printf("\n%d 個檔案的 checksum 不一致 (已標記為受損), 修復後請再次執行遷移。\n", len(damaged))
+	}
+}
+
+// migrateFlushInterval 是遷移時寫入 file_checked.json 的間隔。
+const migrateFlushInterval = 30 * time.Second
+
+// sumTwice 讀取檔案一次，同時計算舊 checksum 的算法與新算法 algo 的 checksum.
+// ctx 取消時停止讀取並返回 ctx.Err().
+func sumTwice(ctx context.Context, f *File, algo string) (oldSum, newSum string, err error) {
+	oldAlgo, _ := util.SplitChecksum(f.Checksum)
+	oldHash, err := util.NewHash(oldAlgo)
+	if err != nil {
+		return
+	}
+	newHash, err := util.NewHash(algo)
+	if err != nil {
+		return
+	}
+	file, err := os.Open(filepath.Join(util.FILES, f.Filename))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	if _, err = io.Copy(io.MultiWriter(oldHash, newHash), ctxReader{ctx, file}); err != nil {
+		return
+	}
+	return util.HashSum(oldAlgo, oldHash), util.HashSum(algo, newHash), nil
+}
+
+// ctxReader 在 ctx 取消後停止讀取。
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr ctxReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
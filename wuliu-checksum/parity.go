@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ahui2016/wuliu/util"
+	"github.com/samber/lo"
+	bolt "go.etcd.io/bbolt"
+)
+
+// updateParity 為沒有 parity 或 parity 已過期的檔案生成 parity, 並刪除多餘的 parity.
+// 已損壞的檔案不生成 parity (以免用受損的內容生成 parity)。
+func updateParity(root string, db *bolt.DB) {
+	percent := MainProject.ParityPercent
+	util.PrintErrorExit(util.CheckParityPercent(percent))
+	fmt.Println("ParityPercent:", percent)
+
+	files := lo.Must(util.GetAllFiles(db))
+	var created, damaged []*File
+	for _, file := range files {
+		if file.Size == 0 || util.ParityUpToDate(root, file, percent) {
+			continue
+		}
+		fmt.Print(".")
+		ok := lo.Must(util.CreateParity(root, file, percent))
+		if ok {
+			created = append(created, file)
+		} else {
+			damaged = append(damaged, file)
+		}
+	}
+	if len(created)+len(damaged) > 0 {
+		fmt.Println()
+	}
+	fmt.Println("已生成 parity 的檔案數量:", len(created))
+
+	filenames := lo.Map(files, func(f *File, _ int) string { return f.Filename })
+	removed := lo.Must(util.RemoveParityOrphans(root, filenames))
+	for _, name := range removed {
+		fmt.Println("Delete =>", name)
+	}
+
+	if len(damaged) > 0 {
+		fmt.Println("以下檔案的 checksum 不一致 (可能已損壞), 未生成 parity:")
+		util.PrintFilesSimple(damaged)
+		fmt.Println("請使用 `wuliu-checksum -check` 檢查或從備份專案修復。")
+	}
+}
+
+// repairDamaged 嘗試使用 parity 修復已損壞的檔案，修復成功的檔案標記為未損壞。
+// 返回修復成功的檔案數量。
+func repairDamaged(root string, fcMap map[string]*FileChecked, db *bolt.DB) (n int) {
+	ids := util.DamagedOfFileChecked(fcMap)
+	for _, id := range ids {
+		file, err := util.GetFileInDB(id, db)
+		if err != nil {
+			continue // 數據庫中沒有的檔案由 printDamaged 處理
+		}
+		ok, err := util.RepairWithParity(root, &file)
+		if err != nil {
+			fmt.Println("使用 parity 修復失敗:", file.Filename, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		fmt.Println("已使用 parity 修復:", id, file.Filename)
+		fcMap[id].Damaged = false
+		fcMap[id].Checked = util.Now()
+		n++
+	}
+	return
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/ahui2016/wuliu/util"
+	"github.com/samber/lo"
+	bolt "go.etcd.io/bbolt"
+)
+
+// quickCheck 快速檢查全部檔案的體積與修改時間，把可疑的檔案標記為 Suspect,
+// 下次執行 `wuliu-checksum -check` 時優先檢查。
+func quickCheck(root string, fcMap map[string]*FileChecked, db *bolt.DB) {
+	fcMap = lo.Must(util.ReconcileFileCheckedMap(fcMap, db))
+	suspects, checkN, err := util.QuickCheck(root, fcMap, db)
+	util.PrintErrorExit(err)
+	fmt.Println("本次快速檢查檔案數量:", checkN)
+	fmt.Println("可疑的檔案:", len(suspects))
+	for _, s := range suspects {
+		fmt.Printf("%s %s (%s)\n", s.ID, s.Filename, s.Problem)
+	}
+	if len(suspects) > 0 {
+		fmt.Println("可疑的檔案會在下次執行 `wuliu-checksum -check` 時優先檢查。")
+	}
+
+	fileCheckedPath := filepath.Join(root, util.FileCheckedPath)
+	fmt.Println("Update =>", fileCheckedPath)
+	_ = lo.Must(
+		util.WriteJSON(fcMap, fileCheckedPath))
+}
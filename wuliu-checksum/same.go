@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ahui2016/wuliu/util"
+	"github.com/samber/lo"
+	bolt "go.etcd.io/bbolt"
+)
+
+// findSame 列印內容相同的檔案，每組中第一個是建議保留的檔案。
+func findSame(db *bolt.DB) {
+	groups := lo.Must(util.FindDuplicates(db))
+	if len(groups) == 0 {
+		fmt.Println("未發現重複檔案")
+		return
+	}
+	var dupIDs []string
+	var dupSize int64
+	for i, group := range groups {
+		size := util.FileSizeToString(float64(group[0].Size), 2)
+		fmt.Printf("[%d] %d 個相同檔案 (%s), 保留: %s\n",
+			i+1, len(group), size, group[0].Filename)
+		util.PrintFilesMore(group)
+		for _, f := range group[1:] {
+			dupIDs = append(dupIDs, f.ID)
+			dupSize += f.Size
+		}
+	}
+	// FindDuplicates 已合併有重疊的組，這裏再去重一次，確保可直接用於 wuliu-delete.
+	dupIDs = lo.Uniq(dupIDs)
+	fmt.Println("重複檔案組數:", len(groups))
+	fmt.Println("可刪除檔案數量:", len(dupIDs))
+	fmt.Println("可刪除檔案體積:", util.FileSizeToString(float64(dupSize), 2))
+
+	if *jsonFlag != "" {
+		writeDupIDs(dupIDs)
+	}
+	if *mergeFlag {
+		util.CheckNotAllowInBackup()
+		mergeDuplicates(groups, db)
+	}
+}
+
+// writeDupIDs 把可刪除的檔案的 ID 寫入 JSON 檔案，可直接用於 wuliu-delete -json.
+func writeDupIDs(ids []string) {
+	if util.PathExists(*jsonFlag) {
+		log.Fatalln("file exists:", *jsonFlag)
+	}
+	fmt.Println("Create =>", *jsonFlag)
+	lo.Must(util.WriteJSON(ids, *jsonFlag))
+}
+
+// mergeDuplicates 把每組重複檔案的屬性 (keywords, collections 等) 合併到保留的檔案中。
+func mergeDuplicates(groups [][]*File, db *bolt.DB) {
+	fmt.Println()
+	var changed int
+	for _, group := range groups {
+		survivor := group[0]
+		merged := util.MergeFileAttrs(*survivor, group[1:]...)
+		if util.FileAttrsEqual(*survivor, merged) {
+			continue
+		}
+		changed++
+		fmt.Println("合併屬性 =>", survivor.Filename)
+		if *danger {
+			lo.Must0(util.UpdateFileAttrs(survivor, &merged, db))
+		} else {
+			util.PrintFilesMore([]*File{&merged})
+		}
+	}
+	fmt.Println("需要合併屬性的檔案數量:", changed)
+	if changed > 0 && !*danger {
+		fmt.Println("(尚未實際執行，使用參數 '-danger' 纔會實際執行)")
+	}
+}
@@ -70,9 +70,12 @@ func deleteFiles(ids []string, db *bolt.DB) {
 	if len(ids) == 0 {
 		return
 	}
-	lo.Must0(util.DeleteFilesByID(ids, db))
+	j := util.NewJournal("delete")
+	lo.Must0(j.Begin())
+	lo.Must0(util.DeleteFilesByID(ids, j, db))
 	lo.Must0(util.RebuildCTimeBucket(db))
 	lo.Must0(util.DeleteFromFileChecked(ids))
+	lo.Must0(j.End())
 }
 
 func readConfig() (ids []string) {
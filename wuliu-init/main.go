@@ -26,11 +26,11 @@ func main() {
 		return
 	}
 	util.FolderMustEmpty(".")
-	util.MakeFolders(true)
+	util.MakeFolders(".", true)
 	lo.Must0(copyTemplates())
 	writeProjectInfo(*nameFlag)
-	util.InitFileChecked()
-	util.CreateDatabase()
+	util.InitFileChecked(".")
+	util.CreateDatabase(".")
 }
 
 // customFlagUsage 必须在 `flag.Parse()` 之前执行才有效。
@@ -67,6 +67,13 @@ func updateMetadata(file File, db *bolt.DB) error {
 	}
 	return db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket(util.FilesBucket)
-		return b.Put([]byte(file.ID), data)
+		old, err := util.GetFileInBucket(file.ID, b)
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte(file.ID), data); err != nil {
+			return err
+		}
+		return util.UpdateIndexes(&old, &file, tx)
 	})
 }
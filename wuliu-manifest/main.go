@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/ahui2016/wuliu/util"
+	"github.com/samber/lo"
+)
+
+var (
+	exportFlag = flag.String("export", "", "write checksums of all files to a manifest (b2sum format)")
+	verifyFlag = flag.String("verify", "", "check files in a folder against a manifest")
+	dirFlag    = flag.String("dir", ".", "use with '-verify', the folder to be checked")
+	algoFlag   = flag.String("algo", util.DefaultHashAlgorithm, "hash algorithm of the manifest")
+)
+
+func main() {
+	flag.Parse()
+	util.PrintErrorExit(util.CheckHashAlgorithm(*algoFlag))
+
+	if *exportFlag != "" {
+		util.MustInWuliu()
+		exportManifest(*exportFlag, *algoFlag)
+		return
+	}
+
+	if *verifyFlag != "" {
+		if ok := verifyManifest(*verifyFlag, *dirFlag, *algoFlag); !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
+	flag.Usage()
+}
+
+// exportManifest 根據數據庫中的 checksum 生成 manifest, 不需要重新計算。
+// 全部檔案都必須有算法 algo 的 checksum (主 checksum 或遷移算法時保留的舊 checksum)。
+func exportManifest(manifestPath, algo string) {
+	if util.PathExists(manifestPath) {
+		log.Fatalln("file exists:", manifestPath)
+	}
+	db := lo.Must(util.OpenDB("."))
+	files := lo.Must(util.GetAllFiles(db))
+	db.Close()
+	slices.SortFunc(files, func(a, b *util.File) int {
+		return cmp.Compare(a.Filename, b.Filename)
+	})
+
+	noSum := lo.Filter(files, func(file *util.File, _ int) bool {
+		return file.ChecksumOf(algo) == ""
+	})
+	if len(noSum) > 0 {
+		util.PrintFilesSimple(noSum)
+		log.Fatalf("以上 %d 個檔案沒有 %s checksum, 請使用參數 '-algo' 指定其他算法\n",
+			len(noSum), algo)
+	}
+
+	f := lo.Must(os.Create(manifestPath))
+	w := bufio.NewWriter(f)
+	for _, file := range files {
+		_, hexSum := util.SplitChecksum(file.ChecksumOf(algo))
+		lo.Must0(util.WriteManifestLine(w, hexSum, file.Filename))
+	}
+	lo.Must0(w.Flush())
+	lo.Must0(f.Close())
+	fmt.Println("檔案數量:", len(files))
+	fmt.Println("Create =>", manifestPath)
+	fmt.Printf("可使用 `wuliu-manifest -verify %s -dir [DIR] -algo %s` 檢查。\n",
+		manifestPath, algo)
+}
+
+// verifyManifest 檢查資料夾 dir 中的檔案與 manifest 是否一致，
+// 列印缺少的檔案、多餘的檔案、checksum 不一致的檔案與無法讀取的檔案。全部一致時返回 true.
+// manifest 中沒有算法前綴的 checksum 使用算法 algo.
+func verifyManifest(manifestPath, dir, algo string) bool {
+	f := lo.Must(os.Open(manifestPath))
+	manifest, err := util.ReadManifest(f)
+	f.Close()
+	util.PrintErrorExit(err)
+
+	names := lo.Must(walkFiles(dir, manifestPath))
+	nameSet := util.StringSliceToSet(names)
+	extra := lo.Filter(names, func(name string, _ int) bool {
+		_, ok := manifest[name]
+		return !ok
+	})
+
+	var missing, mismatched, unreadable []string
+	checkN := 0
+	for _, name := range lo.Keys(manifest) {
+		if !nameSet[name] {
+			missing = append(missing, name)
+			continue
+		}
+		expected := manifest[name]
+		if !strings.Contains(expected, ":") {
+			expected = algo + ":" + expected
+		}
+		ok, err := util.VerifyChecksum(filepath.Join(dir, filepath.FromSlash(name)), expected)
+		fmt.Print(".")
+		checkN++
+		if err != nil {
+			// 一個檔案無法讀取時繼續檢查其他檔案。
+			unreadable = append(unreadable, name+" ("+err.Error()+")")
+			continue
+		}
+		if !ok {
+			mismatched = append(mismatched, name)
+		}
+	}
+	fmt.Println()
+
+	slices.Sort(missing)
+	slices.Sort(mismatched)
+	slices.Sort(unreadable)
+	fmt.Println("manifest 檔案數量:", len(manifest))
+	fmt.Println("已檢查檔案數量:", checkN)
+	printNames("缺少的檔案", missing)
+	printNames("多餘的檔案", extra)
+	printNames("checksum 不一致的檔案", mismatched)
+	printNames("無法讀取的檔案", unreadable)
+	ok := len(missing)+len(extra)+len(mismatched)+len(unreadable) == 0
+	if ok {
+		fmt.Println("OK, 全部一致")
+	}
+	return ok
+}
+
+func printNames(title string, names []string) {
+	fmt.Printf("%s: %d\n", title, len(names))
+	for _, name := range names {
+		fmt.Println("  ", name)
+	}
+}
+
+// walkFiles 返回 dir 中的全部檔案 (包括子資料夾中的檔案) 的相對路徑 (以 "/" 分隔),
+// 不包括 manifest 本身。
+func walkFiles(dir, manifestPath string) (names []string, err error) {
+	manifestAbs, err := filepath.Abs(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		if abs, err := filepath.Abs(path); err != nil || abs == manifestAbs {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	slices.Sort(names)
+	return
+}
@@ -73,6 +73,10 @@ func overwriteMetadata(files []*File, db *bolt.DB) error {
 			if util.PathNotExists(metaPath) {
 				fmt.Println("Warning! 找不到", metaPath)
 			}
+			old, err := util.GetFileInBucket(f.ID, b)
+			if err != nil {
+				return err
+			}
 			data, err := util.WriteJSON(f, metaPath)
 			if err != nil {
 				return err
@@ -80,6 +84,9 @@ func overwriteMetadata(files []*File, db *bolt.DB) error {
 			if err = b.Put([]byte(f.ID), data); err != nil {
 				return err
 			}
+			if err = util.UpdateIndexes(&old, f, tx); err != nil {
+				return err
+			}
 		}
 		return nil
 	})
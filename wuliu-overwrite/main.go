@@ -92,18 +92,26 @@ func printFiles(files map[string]string) {
 }
 
 func overwriteFiles(files map[string]string, db *bolt.DB) error {
-	return db.Update(func(tx *bolt.Tx) error {
+	j := util.NewJournal("overwrite")
+	if err := j.Begin(); err != nil {
+		return err
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
 		filesBuc := tx.Bucket(util.FilesBucket)
 		for name, target := range files {
-			if err := overwriteFile(name, target, filesBuc); err != nil {
+			if err := overwriteFile(name, target, j, filesBuc); err != nil {
 				return err
 			}
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+	return j.End()
 }
 
-func overwriteFile(name, target string, b *bolt.Bucket) error {
+func overwriteFile(name, target string, j *util.Journal, b *bolt.Bucket) error {
 	fmt.Printf("%s <= buffer/%s\n", target, name)
 	if err := checkTarget(target); err != nil {
 		fmt.Println("Warning!", err)
@@ -116,46 +124,63 @@ func overwriteFile(name, target string, b *bolt.Bucket) error {
 		return nil
 	}
 	if target == util.FILES {
-		return overwriteIntoFiles(name, src, dst, b)
+		return overwriteIntoFiles(name, src, dst, j, b)
 	}
 	if target == util.METADATA {
-		return overwriteIntoMetadata(src, dst, b)
+		return overwriteIntoMetadata(src, dst, j, b)
 	}
 	return nil
 }
 
-func overwriteIntoFiles(name, src, dst string, b *bolt.Bucket) error {
+func overwriteIntoFiles(name, src, dst string, j *util.Journal, b *bolt.Bucket) error {
 	metaPath := filepath.Join(util.METADATA, name+".json")
 	f := util.ReadFile(metaPath)
 
 	f.UTime = util.Now()
-	sum, err := util.FileSum512(src)
+	same, err := util.VerifyChecksum(src, f.Checksum)
 	if err != nil {
 		return err
 	}
-	if f.Checksum == sum {
+	if same {
 		fmt.Println("檔案內容沒有變化:", name)
 		return nil
 	}
-	f.Checksum = sum
+	if f.Checksum, err = util.FileSum(src, util.ReadProjectInfo(".").HashAlgo()); err != nil {
+		return err
+	}
+	f.Checksums = nil // 其他算法的 checksum 已失效
 
 	info, err := os.Lstat(src)
 	if err != nil {
 		return err
 	}
 	f.Size = info.Size()
+	f.MTime = util.MTimeOf(info)
 
+	j.Move(src, dst)
+	j.Rewrite(&f)
+	if err = j.Save(); err != nil {
+		return err
+	}
 	if err = os.Rename(src, dst); err != nil {
 		return err
 	}
+	old, err := util.GetFileInBucket(f.ID, b)
+	if err != nil {
+		return err
+	}
 	data, err := util.WriteJSON(f, metaPath)
 	if err != nil {
 		return err
 	}
-	return b.Put([]byte(f.ID), data)
+	if err = b.Put([]byte(f.ID), data); err != nil {
+		return err
+	}
+	// checksum 與體積已改變，需要更新全部索引。
+	return util.UpdateIndexes(&old, &f, b.Tx())
 }
 
-func overwriteIntoMetadata(src, dst string, b *bolt.Bucket) error {
+func overwriteIntoMetadata(src, dst string, j *util.Journal, b *bolt.Bucket) error {
 	f := util.ReadFile(src)
 	old := util.ReadFile(dst)
 
@@ -171,10 +196,19 @@ func overwriteIntoMetadata(src, dst string, b *bolt.Bucket) error {
 	f.ID = old.ID
 	f.Filename = old.Filename
 	f.Checksum = old.Checksum
+	f.Checksums = old.Checksums
 	f.Size = old.Size
 	f.Type = old.Type
 	f.UTime = util.Now()
 
+	j.Rewrite(&f)
+	if err := j.Save(); err != nil {
+		return err
+	}
+	dbFile, err := util.GetFileInBucket(f.ID, b)
+	if err != nil {
+		return err
+	}
 	data, err := util.WriteJSON(f, dst)
 	if err != nil {
 		return err
@@ -182,6 +216,9 @@ func overwriteIntoMetadata(src, dst string, b *bolt.Bucket) error {
 	if err = b.Put([]byte(f.ID), data); err != nil {
 		return err
 	}
+	if err = util.UpdateIndexes(&dbFile, &f, b.Tx()); err != nil {
+		return err
+	}
 	return os.Remove(src)
 }
 
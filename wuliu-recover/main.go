@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/ahui2016/wuliu/util"
+	"github.com/samber/lo"
+)
+
+var (
+	danger = flag.Bool("danger", false, "really do recover")
+)
+
+func main() {
+	flag.Parse()
+	util.MustInWuliu()
+	util.CheckNotAllowInBackup()
+
+	j := lo.Must(util.ReadJournal())
+	if j == nil {
+		fmt.Println("未發現中斷的操作，不需要恢復。")
+		return
+	}
+
+	if *danger {
+		recoverJournal(j)
+	} else {
+		printJournal(j)
+	}
+}
+
+func printJournal(j *util.Journal) {
+	fmt.Printf("\n發現中斷的操作: %s (%s)\n", j.Op, j.Time)
+	fmt.Printf("(尚未實際執行，使用參數 '-danger' 纔會實際執行)\n\n")
+	if j.Op == "overwrite" {
+		fmt.Printf("恢復方法: 重做 (replay)\n\n")
+	} else {
+		fmt.Printf("恢復方法: 回滾 (rollback)\n\n")
+	}
+	for _, m := range j.Moves {
+		fmt.Printf("move: %s => %s\n", m.Src, m.Dst)
+	}
+	for _, name := range j.Created {
+		fmt.Printf("create: %s\n", name)
+	}
+	for _, f := range j.Rewrites {
+		fmt.Printf("rewrite: %s.json\n", f.Filename)
+	}
+	fmt.Println()
+}
+
+func recoverJournal(j *util.Journal) {
+	lo.Must0(j.Recover())
+	util.RebuildDatabase(".")
+
+	db := lo.Must(util.OpenDB("."))
+	defer db.Close()
+	lo.Must0(util.ReconcileFileChecked(db))
+
+	fmt.Println("Delete =>", util.JournalPath)
+	lo.Must0(j.End())
+}
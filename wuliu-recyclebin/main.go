@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/ahui2016/wuliu/util"
+	"github.com/samber/lo"
+	"os"
+	"time"
+)
+
+type RecycleEntry = util.RecycleEntry
+
+const (
+	MB = util.MB
+)
+
+var (
+	listFlag  = flag.Bool("list", false, "list all items in the recyclebin")
+	sizeFlag  = flag.Bool("size", false, "print the total size of the recyclebin")
+	purgeFlag = flag.Bool("purge", false, "delete items according to RecycleMaxAge and RecycleMaxSize")
+	danger    = flag.Bool("danger", false, "really do purge the recyclebin")
+)
+
+func main() {
+	flag.Parse()
+	util.MustInWuliu()
+
+	if !(*listFlag || *sizeFlag || *purgeFlag) {
+		flag.Usage()
+		return
+	}
+
+	projInfo := util.ReadProjectInfo(".")
+	entries := lo.Must(util.ReadRecyclebin())
+
+	if *listFlag {
+		util.PrintRecycleEntries(entries)
+	}
+	if *sizeFlag {
+		printTotalSize(entries, projInfo)
+	}
+	if *purgeFlag {
+		toPurge := entriesToPurge(entries, projInfo.RecycleMaxAge, projInfo.RecycleMaxSize)
+		if *danger {
+			util.CheckNotAllowInBackup()
+			purge(toPurge)
+		} else {
+			printPurge(toPurge, projInfo)
+		}
+	}
+}
+
+func totalSize(entries []*RecycleEntry) (size int64) {
+	for _, e := range entries {
+		size += e.Size
+	}
+	return
+}
+
+func printTotalSize(entries []*RecycleEntry, info util.ProjectInfo) {
+	size := util.FileSizeToString(float64(totalSize(entries)), 2)
+	fmt.Printf("recyclebin: %d 項, %s\n", len(entries), size)
+	printLimits(info)
+}
+
+func printLimits(info util.ProjectInfo) {
+	maxAge := lo.Ternary(info.RecycleMaxAge > 0, fmt.Sprintf("%d 天", info.RecycleMaxAge), "不限")
+	maxSize := lo.Ternary(info.RecycleMaxSize > 0, fmt.Sprintf("%d MB", info.RecycleMaxSize), "不限")
+	fmt.Printf("保留期限 (RecycleMaxAge): %s\n", maxAge)
+	fmt.Printf("體積上限 (RecycleMaxSize): %s\n", maxSize)
+}
+
+// entriesToPurge 找出需要清除的項目。entries 已按刪除時間從新到舊排序，
+// 超過保留期限的項目需要清除，並且從新到舊累計體積，一旦超過體積上限，
+// 該項目及比它更舊的全部項目都需要清除 (即使更舊的項目較小也不保留)。
+// maxAge 或 maxSize 為零表示不限。
+func entriesToPurge(entries []*RecycleEntry, maxAge int, maxSize int64) (toPurge []*RecycleEntry) {
+	deadline := time.Now().AddDate(0, 0, -maxAge).Format(util.RFC3339)
+	var keptSize int64
+	full := false
+	for _, e := range entries {
+		tooOld := maxAge > 0 && e.DeletedAt < deadline
+		full = full || (maxSize > 0 && keptSize+e.Size > maxSize*MB)
+		if tooOld || full {
+			toPurge = append(toPurge, e)
+			continue
+		}
+		keptSize += e.Size
+	}
+	return
+}
+
+func printPurge(toPurge []*RecycleEntry, info util.ProjectInfo) {
+	fmt.Printf("\n清除 recyclebin 預覽:\n")
+	fmt.Printf("(尚未實際執行，使用參數 '-danger' 纔會實際執行)\n\n")
+	printLimits(info)
+	fmt.Println()
+	if len(toPurge) == 0 {
+		fmt.Println("沒有需要清除的項目。")
+		return
+	}
+	util.PrintRecycleEntries(toPurge)
+	size := util.FileSizeToString(float64(totalSize(toPurge)), 2)
+	fmt.Printf("合計: %d 項, %s\n", len(toPurge), size)
+}
+
+func purge(toPurge []*RecycleEntry) {
+	for _, e := range toPurge {
+		fmt.Println("Delete =>", e.Path())
+		lo.Must0(os.RemoveAll(e.Path()))
+	}
+	size := util.FileSizeToString(float64(totalSize(toPurge)), 2)
+	fmt.Printf("已清除: %d 項, %s\n", len(toPurge), size)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/ahui2016/wuliu/util"
+)
+
+func TestEntriesToPurge(t *testing.T) {
+	daysAgo := func(n int) string {
+		return time.Now().AddDate(0, 0, -n).Format(util.RFC3339)
+	}
+	// 已按刪除時間從新到舊排序。
+	entries := []*RecycleEntry{
+		{Name: "a", DeletedAt: daysAgo(1), Size: 2 * MB},
+		{Name: "b", DeletedAt: daysAgo(2), Size: 5 * MB},
+		{Name: "c", DeletedAt: daysAgo(3), Size: 1 * MB},
+		{Name: "d", DeletedAt: daysAgo(40), Size: 1 * MB},
+	}
+	tests := []struct {
+		name    string
+		maxAge  int
+		maxSize int64
+		want    []string
+	}{
+		{"no limits", 0, 0, nil},
+		{"all fit", 0, 9, nil},
+		{"large newer entry purges older smaller ones", 0, 4, []string{"b", "c", "d"}},
+		{"first entry too big", 0, 1, []string{"a", "b", "c", "d"}},
+		{"exact fit", 0, 7, []string{"c", "d"}},
+		{"max age only", 30, 0, []string{"d"}},
+		{"max age and size", 30, 8, []string{"d"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, e := range entriesToPurge(entries, tt.maxAge, tt.maxSize) {
+				got = append(got, e.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -5,11 +5,7 @@ import (
 	"fmt"
 	"github.com/ahui2016/wuliu/util"
 	"github.com/samber/lo"
-	bolt "go.etcd.io/bbolt"
 	"log"
-	"os"
-	"path/filepath"
-	"strings"
 )
 
 var (
@@ -31,88 +27,10 @@ func main() {
 		log.Fatalln("Required '-name'")
 	}
 	if *idFlag != "" && *nameFlag != "" {
-		err := checkFilename(*nameFlag)
-		util.PrintErrorExit(err)
-
-		file, err := util.GetFileInDB(*idFlag, db)
-		util.PrintErrorExit(err)
-
-		fm, err := renameMeta(file.Filename, *nameFlag)
-		util.PrintErrorExit(err)
-
-		err = renameFile(file.Filename, *nameFlag)
-		util.PrintErrorExit(err)
-
-		fmt.Println("Update database...")
-		err = renameInDB(*idFlag, fm, db)
+		err := util.RenameFile(*idFlag, *nameFlag, db)
 		util.PrintErrorExit(err)
 		fmt.Println("OK")
-
 		return
 	}
 	flag.Usage()
 }
-
-func renameMeta(oldname, newname string) (fm util.FileAndMeta, err error) {
-	src := filepath.Join(util.METADATA, oldname+".json")
-	dst := filepath.Join(util.METADATA, newname+".json")
-	fmt.Printf("Rename: %s => %s\n", src, dst)
-	if err = checkExists(src, dst); err != nil {
-		return
-	}
-	file := util.ReadFile(src)
-	file.Filename = newname
-	file.Type = util.TypeByFilename(newname)
-	file.ID = util.NameToID(newname)
-	meta, err := util.WriteJSON(file, dst)
-	if err != nil {
-		return
-	}
-	fm.File = &file
-	fm.Metadata = meta
-
-	rcBin := filepath.Join(util.RECYCLEBIN, oldname+".json")
-	err = os.Rename(src, rcBin)
-	return
-}
-
-func renameFile(oldname, newname string) error {
-	src := filepath.Join(util.FILES, oldname)
-	dst := filepath.Join(util.FILES, newname)
-	fmt.Printf("Rename: %s => %s\n", src, dst)
-	if err := checkExists(src, dst); err != nil {
-		return err
-	}
-	return os.Rename(src, dst)
-}
-
-func checkFilename(name string) (err error) {
-	if strings.ContainsAny(name, `\/:*?"<>|`) {
-		err = fmt.Errorf(`檔案名稱不允許包含這些字符 \/:*?"<>|`)
-	}
-	return
-}
-
-func checkExists(src, dst string) error {
-	if util.PathNotExists(src) {
-		return fmt.Errorf("not found: %s", src)
-	}
-	if util.PathExists(dst) {
-		return fmt.Errorf("file exists: %s", dst)
-	}
-	return nil
-}
-
-func renameInDB(oldID string, newfile util.FileAndMeta, db *bolt.DB) error {
-	return db.Update(func(tx *bolt.Tx) error {
-		filesBuc := tx.Bucket(util.FilesBucket)
-		if err := filesBuc.Delete([]byte(oldID)); err != nil {
-			return err
-		}
-		if err := util.PutToBucket([]byte(newfile.ID), newfile.Metadata, filesBuc); err != nil {
-			return err
-		}
-
-		return util.RenameCTime(newfile.CTime, oldID, newfile.ID, tx)
-	})
-}
@@ -0,0 +1,3 @@
+module github.com/ahui2016/wuliu/wuliu-restore
+
+go 1.21.0
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/ahui2016/wuliu/util"
+	"github.com/samber/lo"
+	bolt "go.etcd.io/bbolt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+type (
+	FileAndMeta  = util.FileAndMeta
+	FileChecked  = util.FileChecked
+	RecycleEntry = util.RecycleEntry
+)
+
+var (
+	listFlag  = flag.Bool("list", false, "list all items in the recyclebin")
+	entryFlag = flag.String("entry", "", "specify an item in the recyclebin by its name")
+	idFlag    = flag.String("id", "", "specify the latest deleted item by the file ID")
+	danger    = flag.Bool("danger", false, "really do restore the file")
+)
+
+func main() {
+	flag.Parse()
+	util.MustInWuliu()
+	util.CheckNotAllowInBackup()
+
+	entries := lo.Must(util.ReadRecyclebin())
+
+	if *listFlag {
+		util.PrintRecycleEntries(entries)
+		return
+	}
+	if *entryFlag+*idFlag == "" {
+		flag.Usage()
+		return
+	}
+	if *entryFlag != "" && *idFlag != "" {
+		log.Fatalln("只能指定 entry 或 id, 不可兩者同時指定。")
+	}
+
+	entry, err := findEntry(entries, *entryFlag, *idFlag)
+	util.PrintErrorExit(err)
+
+	db := lo.Must(util.OpenDB("."))
+	defer db.Close()
+
+	err = checkEntry(entry, db)
+	util.PrintErrorExit(err)
+
+	if *danger {
+		err = restore(entry, db)
+		util.PrintErrorExit(err)
+	} else {
+		fmt.Printf("\n恢復檔案預覽:\n")
+		fmt.Printf("(尚未實際執行，使用參數 '-danger' 纔會實際執行)\n\n")
+		util.PrintRecycleEntries([]*RecycleEntry{entry})
+	}
+}
+
+// findEntry 通過 name 或 id 尋找一項，如果通過 id 尋找，
+// 則返回最新 (最後刪除) 的一項。entries 已按刪除時間從新到舊排序。
+func findEntry(entries []*RecycleEntry, name, id string) (*RecycleEntry, error) {
+	for _, e := range entries {
+		if name != "" && e.Name == name {
+			return e, nil
+		}
+		if id != "" && e.ID == id && e.CanRestore() {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("Not Found: %s", name+id)
+}
+
+func checkEntry(e *RecycleEntry, db *bolt.DB) error {
+	if !e.CanRestore() {
+		return fmt.Errorf("不可恢復 (缺少檔案或 metadata): %s", e.Name)
+	}
+	dstFile := filepath.Join(util.FILES, e.Filename)
+	dstMeta := filepath.Join(util.METADATA, e.Filename+".json")
+	for _, dst := range []string{dstFile, dstMeta} {
+		if util.PathExists(dst) {
+			return fmt.Errorf("file exists: %s", dst)
+		}
+	}
+	if _, err := util.GetFileInDB(e.ID, db); err == nil {
+		return fmt.Errorf("數據庫中已有該 ID: %s", e.ID)
+	}
+	return nil
+}
+
+func restore(e *RecycleEntry, db *bolt.DB) error {
+	srcFile := filepath.Join(e.Path(), e.Filename)
+	srcMeta := filepath.Join(e.Path(), e.Filename+".json")
+	dstFile := filepath.Join(util.FILES, e.Filename)
+	dstMeta := filepath.Join(util.METADATA, e.Filename+".json")
+
+	j := util.NewJournal("restore")
+	j.Move(srcMeta, dstMeta)
+	j.Move(srcFile, dstFile)
+	if err := j.Begin(); err != nil {
+		return err
+	}
+	for _, m := range j.Moves {
+		fmt.Printf("Restore: %s => %s\n", m.Src, m.Dst)
+		if err := os.Rename(m.Src, m.Dst); err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("Update database...")
+	meta, err := os.ReadFile(dstMeta)
+	if err != nil {
+		return err
+	}
+	f := util.ReadFile(dstMeta)
+	if err := util.AddFilesToDB([]FileAndMeta{{File: &f, Metadata: meta}}, db); err != nil {
+		return err
+	}
+	if err := util.RebuildSomeBuckets(db); err != nil {
+		return err
+	}
+	if err := addToFileChecked(f.ID); err != nil {
+		return err
+	}
+	if err := j.End(); err != nil {
+		return err
+	}
+	// 在 journal 結束後纔刪除空資料夾，否則中斷時無法回滾。
+	// 如果刪除失敗，只會在 recyclebin 中留下一個空資料夾。
+	if err := os.Remove(e.Path()); err != nil {
+		return err
+	}
+	fmt.Println("OK")
+	return nil
+}
+
+// addToFileChecked 把上次檢查時間設為 epoch, 使恢復的檔案儘快被檢查。
+func addToFileChecked(id string) error {
+	fcMap, err := util.ReadFileChecked(".")
+	if err != nil {
+		return err
+	}
+	fcMap[id] = &FileChecked{ID: id, Checked: util.Epoch, Damaged: false}
+	_, err = util.WriteJSON(fcMap, util.FileCheckedPath)
+	return err
+}